@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// runModelsCommand implements `transcriber-pro models ...` and returns the
+// process exit code
+func runModelsCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "Usage:")
+		fmt.Fprintln(os.Stderr, "  transcriber-pro models list [--json]")
+		fmt.Fprintln(os.Stderr, "  transcriber-pro models import <file|directory|archive> [--name NAME] [--sha256 HASH] [--allow-unverified]")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	// The config file and env vars decide the model directory; flags are the
+	// subcommand's own
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	offline := *cfg
+	offline.Offline = true
+	models := NewModelManager(&offline)
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("models list", flag.ContinueOnError)
+		asJSON := fs.Bool("json", false, "print JSON")
+		if _, err := parseInterspersed(fs, args[1:]); err != nil {
+			return 2
+		}
+
+		list := models.List()
+		if *asJSON {
+			json.NewEncoder(os.Stdout).Encode(list)
+			return 0
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSIZE\tINSTALLED")
+		for _, info := range list {
+			installed := ""
+			if info.Installed {
+				installed = "yes"
+			}
+			fmt.Fprintf(tw, "%s\t%d MB\t%s\n", info.Name, info.Size/mb, installed)
+		}
+		tw.Flush()
+		return 0
+
+	case "import":
+		fs := flag.NewFlagSet("models import", flag.ContinueOnError)
+		name := fs.String("name", "", "register the model under this name (single file only)")
+		sum := fs.String("sha256", "", "expected SHA-256 of the model file (single file only)")
+		allowUnverified := fs.Bool("allow-unverified", false, "accept models no checksum is known for")
+		paths, err := parseInterspersed(fs, args[1:])
+		if err != nil {
+			return 2
+		}
+		if len(paths) != 1 {
+			usage()
+			return 2
+		}
+
+		imported, err := models.Import(paths[0], ImportOptions{Name: *name, SHA256: *sum, AllowUnverified: *allowUnverified})
+		for _, model := range imported {
+			status := "unverified"
+			if model.Verified {
+				status = "verified"
+			}
+			fmt.Printf("Imported %s (%d MB, sha256 %s, %s)\n", model.Name, model.Size/mb, model.SHA256, status)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Import failed:", err)
+			return 1
+		}
+		fmt.Printf("Models are in %s\n", models.Dir())
+		return 0
+	}
+
+	usage()
+	return 2
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments and returns the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// transcribeInput is one file given to the transcribe command
+type transcribeInput struct {
+	path      string // Audio file to transcribe
+	name      string // Name shown in progress and used for output files
+	translate bool   // Translate to English
+	jobID     string
+	done      bool
+	last      string // Last progress line printed
+}
+
+// runTranscribeCommand implements `transcriber-pro transcribe`: it runs the
+// given files through a headless engine, without the HTTP server, and writes
+// the results in the requested formats. It returns the process exit code,
+// which is non-zero if any file failed.
+func runTranscribeCommand(args []string) int {
+	fs := flag.NewFlagSet("transcribe", flag.ContinueOnError)
+	language := fs.String("language", "", "language code, or auto to detect (default from config)")
+	model := fs.String("model", "", "model to use (default from config)")
+	download := fs.Bool("download", false, "download the --model if it isn't installed")
+	preset := fs.String("preset", "", "reflow SRT and WebVTT cues with a subtitle preset: "+strings.Join(subtitlePresetNames, ", "))
+	wordTimestamps := fs.Bool("word-timestamps", false, "time every word (shown in JSON and karaoke WebVTT)")
+	splitChannels := fs.Bool("split-channels", false, "transcribe each audio channel separately, labelled by speaker")
+	speakers := fs.String("speakers", "", "comma-separated speaker names for the channels, e.g. Agent,Customer")
+	diarize := fs.Bool("diarize", false, "label speakers with the configured diarize command")
+	rttmPath := fs.String("rttm", "", "label speakers from this RTTM file")
+	prompt := fs.String("prompt", "", "initial prompt giving the decoder context, e.g. names and spellings")
+	vocabularyName := fs.String("vocabulary", "", "stored vocabulary whose terms are added to the prompt")
+	task := fs.String("task", taskTranscribe, "transcribe, translate (to English) or both; both writes the translation as <name>.en.<format>")
+	formatList := fs.String("format", "txt", "comma-separated output formats: "+strings.Join(exportFormatNames, ", ")+" or all")
+	outDir := fs.String("out", ".", "directory to write results to, or - for stdout")
+	workers := fs.Int("workers", 0, "files to transcribe at once (default from config)")
+	verbose := fs.Bool("verbose", false, "show server and worker logs")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: transcriber-pro transcribe [flags] <file|-> ...")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	paths, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	if len(paths) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	formats, err := parseFormats(*formatList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 2
+	}
+	subtitles, err := parseSubtitleStyle(url.Values{"preset": {*preset}})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 2
+	}
+	turns, err := readRTTMFile(*rttmPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 2
+	}
+	if *diarize && turns != nil {
+		fmt.Fprintln(os.Stderr, "Error: use either --diarize or --rttm, not both")
+		return 2
+	}
+	if (*diarize || turns != nil) && (*splitChannels || *speakers != "") {
+		fmt.Fprintln(os.Stderr, "Error: diarization can't be combined with --split-channels or --speakers")
+		return 2
+	}
+	if *task, err = parseTask(*task); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 2
+	}
+
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	if *workers > 0 {
+		cfg.Workers = *workers
+	}
+	if *model != "" {
+		// Make the chosen model the default so the engine doesn't fetch another.
+		// Models can be several GB, so only download one when asked to.
+		if !*download && !NewModelManager(cfg).IsInstalled(*model) {
+			fmt.Fprintf(os.Stderr, "Error: model %s is not installed; pass --download to fetch it, or install it with `transcriber-pro models import`\n", *model)
+			return 1
+		}
+		cfg.DefaultModel = *model
+	}
+	if *language == "" {
+		*language = cfg.DefaultLanguage
+	}
+	if *diarize && cfg.DiarizeCommand == "" {
+		fmt.Fprintln(os.Stderr, "Error: --diarize needs diarize_command in the config")
+		return 2
+	}
+
+	// Vocabularies are shared with the server through the state directory
+	var vocabulary *Vocabulary
+	if *vocabularyName != "" {
+		store, err := OpenVocabularyStore(filepath.Join(cfg.StateDir, "vocabularies.json"))
+		if err == nil {
+			vocabulary, err = store.Get(*vocabularyName)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	inputs, cleanup, err := prepareTranscribeInputs(paths)
+	defer cleanup()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	inputs = taskInputs(inputs, *task)
+
+	if *outDir != "-" {
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+	}
+
+	engine, err := NewHeadlessEngine(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	defer engine.Close()
+
+	resolved, err := engine.Models().Resolve(*model)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	initialPrompt, termsDropped := buildPrompt(*prompt, vocabulary)
+	if termsDropped > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: the last %d terms of vocabulary %s don't fit in the prompt and are left out\n", termsDropped, *vocabularyName)
+	}
+
+	for _, input := range inputs {
+		input.jobID = uuid.New().String()
+		engine.CreateJob(input.jobID, input.name, input.path, JobOptions{
+			Language:       *language,
+			Model:          resolved,
+			Prompt:         initialPrompt,
+			Vocabulary:     *vocabularyName,
+			TermsDropped:   termsDropped,
+			WordTimestamps: *wordTimestamps,
+			SplitChannels:  *splitChannels || *speakers != "",
+			Speakers:       parseSpeakers(*speakers),
+			Diarize:        *diarize,
+			SpeakerTurns:   turns,
+			Translate:      input.translate,
+		})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	failed := 0
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for remaining := len(inputs); remaining > 0; {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(os.Stderr, "\nInterrupted, cancelling jobs...")
+			for _, input := range inputs {
+				if !input.done {
+					engine.CancelJob(input.jobID)
+				}
+			}
+			return 130
+		case <-ticker.C:
+		}
+
+		for _, input := range inputs {
+			if input.done {
+				continue
+			}
+			job := engine.GetJob(input.jobID)
+			if job == nil {
+				continue
+			}
+
+			switch job.Status {
+			case StatusCompleted:
+				input.done = true
+				remaining--
+				if err := writeTranscribeOutputs(*outDir, input.name, job.Result, formats, subtitles); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: failed to write output: %v\n", input.name, err)
+					failed++
+				} else {
+					fmt.Fprintf(os.Stderr, "%s: done\n", input.name)
+				}
+			case StatusFailed:
+				input.done = true
+				remaining--
+				failed++
+				fmt.Fprintf(os.Stderr, "%s: failed: %s\n", input.name, job.Error)
+			default:
+				line := job.Message
+				if job.ETA != "" {
+					line += " (" + job.ETA + ")"
+				}
+				if line != input.last {
+					input.last = line
+					fmt.Fprintf(os.Stderr, "%s: %s\n", input.name, line)
+				}
+			}
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d file(s) failed\n", failed, len(inputs))
+		return 1
+	}
+	return 0
+}
+
+// prepareTranscribeInputs checks the input files and spools stdin ("-") to a
+// temporary file. Output names are the file names without their extension,
+// unless two inputs would collide.
+func prepareTranscribeInputs(paths []string) ([]*transcribeInput, func(), error) {
+	var tmpFiles []string
+	cleanup := func() {
+		for _, path := range tmpFiles {
+			os.Remove(path)
+		}
+	}
+
+	inputs := make([]*transcribeInput, 0, len(paths))
+	counts := make(map[string]int)
+	usedStdin := false
+	for _, path := range paths {
+		if path == "-" {
+			if usedStdin {
+				return nil, cleanup, errors.New("stdin (-) can only be given once")
+			}
+			usedStdin = true
+
+			tmp, err := os.CreateTemp("", "transcriber-stdin-*")
+			if err != nil {
+				return nil, cleanup, err
+			}
+			tmpFiles = append(tmpFiles, tmp.Name())
+			_, err = io.Copy(tmp, os.Stdin)
+			tmp.Close()
+			if err != nil {
+				return nil, cleanup, fmt.Errorf("failed to read stdin: %w", err)
+			}
+			inputs = append(inputs, &transcribeInput{path: tmp.Name(), name: "stdin"})
+			counts["stdin"]++
+			continue
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		if !stat.Mode().IsRegular() {
+			return nil, cleanup, fmt.Errorf("%s is not a regular file", path)
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		base := filepath.Base(path)
+		name := strings.TrimSuffix(base, filepath.Ext(base))
+		inputs = append(inputs, &transcribeInput{path: abs, name: name})
+		counts[name]++
+	}
+
+	// a.mp3 and a.m4a would both write a.txt, so keep their extensions
+	for _, input := range inputs {
+		if counts[input.name] > 1 && input.name != "stdin" {
+			input.name = filepath.Base(input.path)
+		}
+	}
+	return inputs, cleanup, nil
+}
+
+// taskInputs sets up inputs for task. With taskBoth every file is queued a
+// second time for its translation, named <name>.en; the headless engine never
+// deletes its audio, so both jobs can read the same file.
+func taskInputs(inputs []*transcribeInput, task string) []*transcribeInput {
+	switch task {
+	case taskTranslate:
+		for _, input := range inputs {
+			input.translate = true
+		}
+	case taskBoth:
+		for _, input := range inputs {
+			inputs = append(inputs, &transcribeInput{path: input.path, name: input.name + ".en", translate: true})
+		}
+	}
+	return inputs
+}
+
+// writeTranscribeOutputs writes a result in every format to dir, or to stdout
+// if dir is "-". Subtitle cues are reflowed if subtitles isn't nil.
+func writeTranscribeOutputs(dir, name string, result *TranscriptionResult, formats []string, subtitles *SubtitleStyle) error {
+	if result == nil {
+		return errors.New("job finished without a result")
+	}
+	opts := ExportOptions{Source: name, Subtitles: subtitles}
+	for _, format := range formats {
+		if dir == "-" {
+			data, err := renderExport(result, format, opts)
+			if err != nil {
+				return err
+			}
+			os.Stdout.Write(data)
+			if len(data) > 0 && data[len(data)-1] != '\n' {
+				os.Stdout.Write([]byte{'\n'})
+			}
+			continue
+		}
+
+		path, err := writeExport(dir, name, result, format, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s: wrote %s\n", name, path)
+	}
+	return nil
+}
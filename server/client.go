@@ -0,0 +1,849 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Client talks to a running server's HTTP API
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{},
+	}
+}
+
+// APIError is an error response from the server
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// JobProgress is the response of GET /progress/:job_id
+type JobProgress struct {
+	Status   JobStatus            `json:"status"`
+	Progress float64              `json:"progress"`
+	Message  string               `json:"message"`
+	ETA      string               `json:"eta"`
+	Result   *TranscriptionResult `json:"result,omitempty"`
+	Partial  *PartialProgress     `json:"partial,omitempty"`
+	Error    string               `json:"error,omitempty"`
+}
+
+// PartialProgress holds the segments decoded so far
+type PartialProgress struct {
+	Segments     []TranscriptionSegment `json:"segments"`
+	SegmentCount int                    `json:"segment_count"`
+	Language     string                 `json:"language"`
+}
+
+// Finished reports whether the job has completed or failed
+func (p *JobProgress) Finished() bool {
+	return p.Status == StatusCompleted || p.Status == StatusFailed
+}
+
+// do sends a request and decodes a JSON response into out, turning error
+// responses into an *APIError
+func (c *Client) do(method, path string, body io.Reader, contentType string, out any) error {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = strings.TrimSpace(string(data))
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: apiErr.Error}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw, err = io.ReadAll(resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Queue returns the jobs waiting or running and the finished ones
+func (c *Client) Queue() (queue, completed []Job, err error) {
+	var resp struct {
+		Queue     []Job `json:"queue"`
+		Completed []Job `json:"completed"`
+	}
+	if err := c.do(http.MethodGet, "/queue", nil, "", &resp); err != nil {
+		return nil, nil, err
+	}
+	return resp.Queue, resp.Completed, nil
+}
+
+// Submit uploads an audio file for task ("transcribe", "translate" or
+// "both", empty for transcribe) and returns the new jobs' IDs: one, or the
+// transcription's and the translation's for "both". The file is streamed, so
+// large recordings are never held in memory.
+func (c *Client) Submit(path, task string, opts JobOptions) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		err := func() error {
+			if opts.Language != "" {
+				if err := mw.WriteField("language", opts.Language); err != nil {
+					return err
+				}
+			}
+			if opts.Model != "" {
+				if err := mw.WriteField("model", opts.Model); err != nil {
+					return err
+				}
+			}
+			if opts.CallbackURL != "" {
+				if err := mw.WriteField("callback_url", opts.CallbackURL); err != nil {
+					return err
+				}
+			}
+			if task != "" {
+				if err := mw.WriteField("task", task); err != nil {
+					return err
+				}
+			}
+			if opts.Prompt != "" {
+				if err := mw.WriteField("initial_prompt", opts.Prompt); err != nil {
+					return err
+				}
+			}
+			if opts.Vocabulary != "" {
+				if err := mw.WriteField("vocabulary", opts.Vocabulary); err != nil {
+					return err
+				}
+			}
+			if opts.WordTimestamps {
+				if err := mw.WriteField("word_timestamps", "true"); err != nil {
+					return err
+				}
+			}
+			if opts.SplitChannels {
+				if err := mw.WriteField("split_channels", "true"); err != nil {
+					return err
+				}
+			}
+			if len(opts.Speakers) > 0 {
+				if err := mw.WriteField("speakers", strings.Join(opts.Speakers, ",")); err != nil {
+					return err
+				}
+			}
+			if len(opts.SpeakerTurns) > 0 {
+				part, err := mw.CreateFormFile("rttm", "speakers.rttm")
+				if err != nil {
+					return err
+				}
+				if _, err := io.WriteString(part, formatRTTM("audio", opts.SpeakerTurns)); err != nil {
+					return err
+				}
+			}
+			if opts.Diarize {
+				if err := mw.WriteField("diarize", "true"); err != nil {
+					return err
+				}
+			}
+			part, err := mw.CreateFormFile("audio", filepath.Base(path))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(part, file); err != nil {
+				return err
+			}
+			return mw.Close()
+		}()
+		pw.CloseWithError(err)
+	}()
+
+	var resp struct {
+		JobID            string `json:"job_id"`
+		TranslationJobID string `json:"translation_job_id"`
+	}
+	if err := c.do(http.MethodPost, "/transcribe", pr, mw.FormDataContentType(), &resp); err != nil {
+		pr.Close()
+		return nil, err
+	}
+	if resp.TranslationJobID != "" {
+		return []string{resp.JobID, resp.TranslationJobID}, nil
+	}
+	return []string{resp.JobID}, nil
+}
+
+// Progress returns a job's state. Only partial segments after the first
+// since are included.
+func (c *Client) Progress(jobID string, since int) (*JobProgress, error) {
+	path := "/progress/" + url.PathEscape(jobID)
+	if since > 0 {
+		path += "?since=" + strconv.Itoa(since)
+	}
+	var progress JobProgress
+	if err := c.do(http.MethodGet, path, nil, "", &progress); err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// Export returns a finished job's transcript in the named format. params
+// carries format options such as the WebVTT cue settings.
+func (c *Client) Export(jobID, format string, params url.Values) ([]byte, error) {
+	query := url.Values{"format": {format}}
+	for key, values := range params {
+		query[key] = values
+	}
+	var data []byte
+	if err := c.do(http.MethodGet, "/jobs/"+url.PathEscape(jobID)+"/export?"+query.Encode(), nil, "", &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Kill terminates the worker running a job
+func (c *Client) Kill(jobID string) error {
+	return c.do(http.MethodPost, "/kill-job/"+url.PathEscape(jobID), nil, "", nil)
+}
+
+// Cancel removes a job from the queue, stopping it if it is running
+func (c *Client) Cancel(jobID string) error {
+	return c.do(http.MethodPost, "/cancel-job/"+url.PathEscape(jobID), nil, "", nil)
+}
+
+// Clear removes finished jobs, or every job that isn't running if all is set
+func (c *Client) Clear(all bool) error {
+	path := "/clear-completed"
+	if all {
+		path = "/clear-all"
+	}
+	return c.do(http.MethodPost, path, nil, "", nil)
+}
+
+// Vocabularies returns the vocabularies stored on the server
+func (c *Client) Vocabularies() ([]Vocabulary, error) {
+	var resp struct {
+		Vocabularies []Vocabulary `json:"vocabularies"`
+	}
+	if err := c.do(http.MethodGet, "/vocabularies", nil, "", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Vocabularies, nil
+}
+
+// Vocabulary returns one stored vocabulary
+func (c *Client) Vocabulary(name string) (*Vocabulary, error) {
+	var vocabulary Vocabulary
+	if err := c.do(http.MethodGet, "/vocabularies/"+url.PathEscape(name), nil, "", &vocabulary); err != nil {
+		return nil, err
+	}
+	return &vocabulary, nil
+}
+
+// PutVocabulary creates or replaces a vocabulary
+func (c *Client) PutVocabulary(name string, terms []string) (*Vocabulary, error) {
+	body, err := json.Marshal(map[string][]string{"terms": terms})
+	if err != nil {
+		return nil, err
+	}
+	var vocabulary Vocabulary
+	if err := c.do(http.MethodPut, "/vocabularies/"+url.PathEscape(name), bytes.NewReader(body), "application/json", &vocabulary); err != nil {
+		return nil, err
+	}
+	return &vocabulary, nil
+}
+
+// DeleteVocabulary removes a vocabulary
+func (c *Client) DeleteVocabulary(name string) error {
+	return c.do(http.MethodDelete, "/vocabularies/"+url.PathEscape(name), nil, "", nil)
+}
+
+// clientCommands are the subcommands that control a running server
+var clientCommands = map[string]func(c *Client, asJSON bool, args []string) int{
+	"queue":  runQueueCommand,
+	"submit": runSubmitCommand,
+	"watch":  runWatchCommand,
+	"kill":   runKillCommand,
+	"cancel": runCancelCommand,
+	"clear":  runClearCommand,
+	"export": runExportCommand,
+	"vocab":  runVocabCommand,
+}
+
+// defaultServerURL returns TRANSCRIBER_SERVER, or the address the local
+// configuration would listen on
+func defaultServerURL() string {
+	if server := os.Getenv("TRANSCRIBER_SERVER"); server != "" {
+		return server
+	}
+	if cfg, err := LoadConfig(nil); err == nil {
+		return cfg.ServerURL()
+	}
+	return "http://localhost:8456"
+}
+
+// runClientCommand runs a client subcommand and returns the process exit
+// code. --server and --json may appear anywhere in args.
+func runClientCommand(name string, args []string) int {
+	run, ok := clientCommands[name]
+	if !ok {
+		return 2
+	}
+
+	server := ""
+	asJSON := false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--json" || arg == "-json":
+			asJSON = true
+		case arg == "--server" || arg == "-server":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "--server needs a URL")
+				return 2
+			}
+			i++
+			server = args[i]
+		case strings.HasPrefix(arg, "--server=") || strings.HasPrefix(arg, "-server="):
+			server = arg[strings.Index(arg, "=")+1:]
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if server == "" {
+		server = defaultServerURL()
+	}
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+
+	return run(NewClient(server), asJSON, rest)
+}
+
+// clientFail prints err and returns the exit code for it
+func clientFail(err error) int {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	return 1
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func runQueueCommand(c *Client, asJSON bool, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: transcriber-pro queue [--server URL] [--json]")
+		return 2
+	}
+
+	queue, completed, err := c.Queue()
+	if err != nil {
+		return clientFail(err)
+	}
+	if asJSON {
+		printJSON(map[string][]Job{"queue": queue, "completed": completed})
+		return 0
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tPROGRESS\tFILE\tMESSAGE")
+	for _, job := range append(queue, completed...) {
+		message := job.Message
+		if job.Status == StatusFailed && job.Error != "" {
+			message = job.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.0f%%\t%s\t%s\n", job.ID, job.Status, job.Progress, job.FileName, message)
+	}
+	tw.Flush()
+	return 0
+}
+
+func runSubmitCommand(c *Client, asJSON bool, args []string) int {
+	fs := flag.NewFlagSet("submit", flag.ContinueOnError)
+	language := fs.String("language", "", "language code, or auto to detect (default: server setting)")
+	model := fs.String("model", "", "model to use (default: server setting)")
+	callbackURL := fs.String("callback-url", "", "webhook to notify of each job's lifecycle events")
+	wordTimestamps := fs.Bool("word-timestamps", false, "time every word of the transcript")
+	splitChannels := fs.Bool("split-channels", false, "transcribe each audio channel separately, labelled by speaker")
+	speakers := fs.String("speakers", "", "comma-separated speaker names for the channels, e.g. Agent,Customer")
+	diarize := fs.Bool("diarize", false, "label speakers with the server's diarize command")
+	rttmPath := fs.String("rttm", "", "label speakers from this RTTM file")
+	task := fs.String("task", "", "transcribe, translate (to English) or both (two linked jobs) (default transcribe)")
+	prompt := fs.String("prompt", "", "initial prompt giving the decoder context, e.g. names and spellings")
+	vocabulary := fs.String("vocabulary", "", "stored vocabulary whose terms are added to the prompt (see vocab)")
+	watch := fs.Bool("watch", false, "watch the jobs until they finish")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: transcriber-pro submit [--server URL] [--json] [flags] <file> ...")
+		fs.PrintDefaults()
+	}
+	paths, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(paths) == 0 {
+		fs.Usage()
+		return 2
+	}
+	turns, err := readRTTMFile(*rttmPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 2
+	}
+
+	type submitted struct {
+		File             string `json:"file"`
+		JobID            string `json:"job_id,omitempty"`
+		TranslationJobID string `json:"translation_job_id,omitempty"` // For --task both
+		Error            string `json:"error,omitempty"`
+	}
+	var results []submitted
+	failed := 0
+	for _, path := range paths {
+		jobIDs, err := c.Submit(path, *task, JobOptions{
+			Language:       *language,
+			Model:          *model,
+			CallbackURL:    *callbackURL,
+			Prompt:         *prompt,
+			Vocabulary:     *vocabulary,
+			WordTimestamps: *wordTimestamps,
+			SplitChannels:  *splitChannels || *speakers != "",
+			Speakers:       parseSpeakers(*speakers),
+			Diarize:        *diarize,
+			SpeakerTurns:   turns,
+		})
+		if err != nil {
+			failed++
+			results = append(results, submitted{File: path, Error: err.Error()})
+			if !asJSON {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			}
+			continue
+		}
+		result := submitted{File: path, JobID: jobIDs[0]}
+		if len(jobIDs) > 1 {
+			result.TranslationJobID = jobIDs[1]
+		}
+		results = append(results, result)
+		if !asJSON {
+			for _, jobID := range jobIDs {
+				fmt.Println(jobID)
+			}
+		}
+	}
+	if asJSON {
+		printJSON(results)
+	}
+
+	if *watch {
+		for _, result := range results {
+			for _, jobID := range []string{result.JobID, result.TranslationJobID} {
+				if jobID == "" {
+					continue
+				}
+				if code := watchJob(c, jobID, asJSON); code != 0 {
+					failed++
+				}
+			}
+		}
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runWatchCommand(c *Client, asJSON bool, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: transcriber-pro watch [--server URL] [--json] <job-id>")
+		return 2
+	}
+	return watchJob(c, args[0], asJSON)
+}
+
+// watchJob tails a job until it finishes: segments are printed to stdout as
+// they are decoded and progress changes to stderr. With asJSON every change
+// is printed as one JSON line instead. Returns 0 if the job completed.
+func watchJob(c *Client, jobID string, asJSON bool) int {
+	seen := 0
+	var printed printedSegments
+	lastLine := ""
+	failures := 0
+
+	for {
+		progress, err := c.Progress(jobID, seen)
+		if err != nil {
+			var apiErr *APIError
+			if errors.As(err, &apiErr) || failures >= 5 {
+				return clientFail(err)
+			}
+			// Ride out a brief network hiccup
+			failures++
+			time.Sleep(time.Second)
+			continue
+		}
+		failures = 0
+
+		var segments []TranscriptionSegment
+		if progress.Partial != nil {
+			segments = progress.Partial.Segments
+		} else if progress.Result != nil {
+			// The job finished between polls; its last segments are in the
+			// result, though not necessarily at the end of it
+			for _, segment := range progress.Result.Segments {
+				if !printed.covers(segment) {
+					segments = append(segments, segment)
+				}
+			}
+		}
+
+		line := fmt.Sprintf("%s %.0f%% %s %s", progress.Status, progress.Progress, progress.Message, progress.ETA)
+		if asJSON {
+			if line != lastLine || len(segments) > 0 || progress.Finished() {
+				json.NewEncoder(os.Stdout).Encode(progress)
+			}
+		} else {
+			for _, segment := range segments {
+				fmt.Printf("[%s --> %s] %s%s\n", formatVTTTime(segment.Start), formatVTTTime(segment.End), speakerPrefix(segment, ""), strings.TrimSpace(segment.Text))
+			}
+			if line != lastLine && !progress.Finished() {
+				status := progress.Message
+				if progress.ETA != "" {
+					status += " (" + progress.ETA + ")"
+				}
+				fmt.Fprintln(os.Stderr, status)
+			}
+		}
+		seen += len(segments)
+		printed = append(printed, segments...)
+		lastLine = line
+
+		switch progress.Status {
+		case StatusCompleted:
+			if !asJSON {
+				fmt.Fprintln(os.Stderr, "Completed")
+			}
+			return 0
+		case StatusFailed:
+			if !asJSON {
+				fmt.Fprintln(os.Stderr, "Failed:", progress.Error)
+			}
+			return 1
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// printedSegments are the segments watchJob has printed. The final result
+// doesn't simply extend them: split-channel jobs interleave the channels'
+// segments by time, and diarization splits segments where the speaker
+// changes.
+type printedSegments []TranscriptionSegment
+
+// covers reports whether segment, from the final result, was already printed,
+// whole or as part of a segment that had no speaker yet
+func (p printedSegments) covers(segment TranscriptionSegment) bool {
+	text := strings.Join(strings.Fields(segment.Text), " ")
+	for _, printed := range p {
+		if printed.Start == segment.Start && printed.End == segment.End && printed.Speaker == segment.Speaker {
+			return true
+		}
+		if printed.Speaker == "" && segment.Start >= printed.Start && segment.End <= printed.End &&
+			strings.Contains(strings.Join(strings.Fields(printed.Text), " "), text) {
+			return true
+		}
+	}
+	return false
+}
+
+func runKillCommand(c *Client, asJSON bool, args []string) int {
+	return runJobAction(c, asJSON, args, "kill", c.Kill)
+}
+
+func runCancelCommand(c *Client, asJSON bool, args []string) int {
+	return runJobAction(c, asJSON, args, "cancel", c.Cancel)
+}
+
+// runJobAction applies a kill or cancel to every job ID in args
+func runJobAction(c *Client, asJSON bool, args []string, name string, action func(string) error) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: transcriber-pro %s [--server URL] [--json] <job-id> ...\n", name)
+		return 2
+	}
+
+	type outcome struct {
+		JobID string `json:"job_id"`
+		OK    bool   `json:"ok"`
+		Error string `json:"error,omitempty"`
+	}
+	var outcomes []outcome
+	failed := 0
+	for _, jobID := range args {
+		if err := action(jobID); err != nil {
+			failed++
+			outcomes = append(outcomes, outcome{JobID: jobID, Error: err.Error()})
+			if !asJSON {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", jobID, err)
+			}
+			continue
+		}
+		outcomes = append(outcomes, outcome{JobID: jobID, OK: true})
+		if !asJSON {
+			fmt.Printf("%s: %s\n", jobID, map[string]string{"kill": "killed", "cancel": "cancelled"}[name])
+		}
+	}
+	if asJSON {
+		printJSON(outcomes)
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runClearCommand(c *Client, asJSON bool, args []string) int {
+	fs := flag.NewFlagSet("clear", flag.ContinueOnError)
+	all := fs.Bool("all", false, "also remove queued jobs (running jobs are kept)")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: transcriber-pro clear [--server URL] [--json] [--all]")
+		return 2
+	}
+
+	if err := c.Clear(*all); err != nil {
+		return clientFail(err)
+	}
+	if asJSON {
+		printJSON(map[string]bool{"ok": true})
+	} else if *all {
+		fmt.Println("Cleared all jobs that are not running")
+	} else {
+		fmt.Println("Cleared finished jobs")
+	}
+	return 0
+}
+
+func runExportCommand(c *Client, asJSON bool, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "txt", "output format: "+strings.Join(exportFormatNames, ", ")+" or "+zipFormat)
+	out := fs.String("out", "-", "file to write, or - for stdout")
+	position := fs.String("position", "", "WebVTT cue position, e.g. 50% or 10%,line-left")
+	line := fs.String("line", "", "WebVTT cue line, e.g. -2 or 85%,end")
+	align := fs.String("align", "", "WebVTT cue alignment: start, center, end, left or right")
+	noNote := fs.Bool("no-note", false, "leave out the WebVTT NOTE block")
+	timestamps := fs.Bool("timestamps", false, "start every TXT and Markdown segment with its start time")
+	maxLineLength := fs.Int("max-line-length", 0, "wrap text at this many characters (0: no wrapping)")
+	offset := fs.Float64("offset", 0, "seconds added to every timestamp")
+	preset := fs.String("preset", "", "reflow subtitle cues with a preset: "+strings.Join(subtitlePresetNames, ", "))
+	ids, err := parseInterspersed(fs, args)
+	if err != nil || len(ids) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: transcriber-pro export [--server URL] <job-id> [--format FORMAT] [--out FILE] [--timestamps] [--max-line-length N] [--offset SECONDS] [--preset NAME] [--position P] [--line L] [--align A] [--no-note]")
+		return 2
+	}
+	if asJSON {
+		*format = "json"
+	}
+	if _, ok := exportFormats[*format]; !ok && *format != zipFormat {
+		return clientFail(fmt.Errorf("unknown format %q (supported: %s, %s)", *format, strings.Join(exportFormatNames, ", "), zipFormat))
+	}
+
+	params := url.Values{}
+	if *timestamps {
+		params.Set("timestamps", "true")
+	}
+	if *maxLineLength > 0 {
+		params.Set("max_line_length", strconv.Itoa(*maxLineLength))
+	}
+	if *offset != 0 {
+		params.Set("offset", strconv.FormatFloat(*offset, 'f', -1, 64))
+	}
+	if *preset != "" {
+		params.Set("preset", *preset)
+	}
+	for key, value := range map[string]string{"position": *position, "line": *line, "align": *align} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	if *noNote {
+		params.Set("note", "0")
+	}
+
+	data, err := c.Export(ids[0], *format, params)
+	if err != nil {
+		return clientFail(err)
+	}
+	if *out == "-" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		return clientFail(err)
+	}
+	return 0
+}
+
+// runVocabCommand implements `transcriber-pro vocab`, which manages the
+// vocabularies stored on the server
+func runVocabCommand(c *Client, asJSON bool, args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "Usage:")
+		fmt.Fprintln(os.Stderr, "  transcriber-pro vocab list [--server URL] [--json]")
+		fmt.Fprintln(os.Stderr, "  transcriber-pro vocab show <name>")
+		fmt.Fprintln(os.Stderr, "  transcriber-pro vocab set <name> [--file FILE] [term ...]")
+		fmt.Fprintln(os.Stderr, "  transcriber-pro vocab delete <name>")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return usage()
+		}
+		list, err := c.Vocabularies()
+		if err != nil {
+			return clientFail(err)
+		}
+		if asJSON {
+			printJSON(list)
+			return 0
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tTERMS\tUPDATED")
+		for _, vocabulary := range list {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", vocabulary.Name, len(vocabulary.Terms), vocabulary.UpdatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		tw.Flush()
+		return 0
+
+	case "show":
+		if len(args) != 2 {
+			return usage()
+		}
+		vocabulary, err := c.Vocabulary(args[1])
+		if err != nil {
+			return clientFail(err)
+		}
+		if asJSON {
+			printJSON(vocabulary)
+			return 0
+		}
+		for _, term := range vocabulary.Terms {
+			fmt.Println(term)
+		}
+		return 0
+
+	case "set":
+		fs := flag.NewFlagSet("vocab set", flag.ContinueOnError)
+		file := fs.String("file", "", "read terms from this file, one per line (- for stdin)")
+		rest, err := parseInterspersed(fs, args[1:])
+		if err != nil || len(rest) == 0 {
+			return usage()
+		}
+		terms := rest[1:]
+		if *file != "" {
+			lines, err := readTermFile(*file)
+			if err != nil {
+				return clientFail(err)
+			}
+			terms = append(terms, lines...)
+		}
+		vocabulary, err := c.PutVocabulary(rest[0], terms)
+		if err != nil {
+			return clientFail(err)
+		}
+		if asJSON {
+			printJSON(vocabulary)
+		} else {
+			fmt.Printf("Saved %s with %d terms\n", vocabulary.Name, len(vocabulary.Terms))
+		}
+		return 0
+
+	case "delete":
+		if len(args) != 2 {
+			return usage()
+		}
+		if err := c.DeleteVocabulary(args[1]); err != nil {
+			return clientFail(err)
+		}
+		if asJSON {
+			printJSON(map[string]bool{"ok": true})
+		} else {
+			fmt.Printf("Deleted %s\n", args[1])
+		}
+		return 0
+	}
+	return usage()
+}
+
+// readTermFile reads one term per line from path, or stdin if path is "-".
+// Blank lines and lines starting with # are skipped.
+func readTermFile(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var terms []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			terms = append(terms, line)
+		}
+	}
+	return terms, nil
+}
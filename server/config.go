@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the server settings. Each setting is layered: the built-in
+// default, then the config file, then environment variables, then
+// command-line flags.
+type Config struct {
+	Listen          string `yaml:"listen" json:"listen"`
+	UploadDir       string `yaml:"upload_dir" json:"upload_dir"`
+	OutputDir       string `yaml:"output_dir" json:"output_dir"`
+	ModelDir        string `yaml:"model_dir" json:"model_dir"`
+	StateDir        string `yaml:"state_dir" json:"state_dir"`
+	MaxUploadMB     int64  `yaml:"max_upload_mb" json:"max_upload_mb"`
+	DefaultLanguage string `yaml:"default_language" json:"default_language"`
+	DefaultModel    string `yaml:"default_model" json:"default_model"`
+	ModelURL        string `yaml:"model_url" json:"model_url"`
+	Offline         bool   `yaml:"offline" json:"offline"`
+	Workers         int    `yaml:"workers" json:"workers"`
+	Threads         int    `yaml:"threads" json:"threads"` // Threads per worker; 0 splits the CPUs between workers
+	WorkerMaxJobs   int    `yaml:"worker_max_jobs" json:"worker_max_jobs"`
+	WorkerMaxRSSMB  int64  `yaml:"worker_max_rss_mb" json:"worker_max_rss_mb"`
+	OpenBrowser     bool   `yaml:"open_browser" json:"open_browser"`
+	WebhookURL      string `yaml:"webhook_url" json:"webhook_url"`
+	WebhookSecret   string `yaml:"webhook_secret" json:"-"` // Never served by /config
+	DiarizeCommand  string `yaml:"diarize_command" json:"diarize_command"`
+
+	// Hot folders whose new recordings are queued automatically. Only the
+	// config file and --watch set these.
+	WatchFolders []WatchFolder `yaml:"watch_folders" json:"watch_folders"`
+
+	file    string            // Config file that was loaded, if any
+	sources map[string]string // Where each setting's value came from
+}
+
+// configSetting ties a Config field to its config file key, env var and flag
+type configSetting struct {
+	key   string // Config file key; the flag is the same with dashes
+	env   string
+	usage string
+	field func(*Config) any // Pointer to the field
+}
+
+var configSettings = []configSetting{
+	{"listen", "TRANSCRIBER_LISTEN", "address to listen on (host:port)", func(c *Config) any { return &c.Listen }},
+	{"upload_dir", "TRANSCRIBER_UPLOAD_DIR", "directory for uploaded audio", func(c *Config) any { return &c.UploadDir }},
+	{"output_dir", "TRANSCRIBER_OUTPUT_DIR", "directory for saved transcriptions", func(c *Config) any { return &c.OutputDir }},
+	{"model_dir", "TRANSCRIBER_MODEL_DIR", "directory holding ggml models", func(c *Config) any { return &c.ModelDir }},
+	{"state_dir", "TRANSCRIBER_STATE_DIR", "directory for the job journal and audit logs", func(c *Config) any { return &c.StateDir }},
+	{"max_upload_mb", "TRANSCRIBER_MAX_UPLOAD_MB", "largest accepted upload in MB", func(c *Config) any { return &c.MaxUploadMB }},
+	{"default_language", "TRANSCRIBER_LANGUAGE", "language used when a job doesn't pick one (auto to detect)", func(c *Config) any { return &c.DefaultLanguage }},
+	{"default_model", "TRANSCRIBER_MODEL", "model used when a job doesn't pick one", func(c *Config) any { return &c.DefaultModel }},
+	{"model_url", "TRANSCRIBER_MODEL_URL", "base URL models are downloaded from", func(c *Config) any { return &c.ModelURL }},
+	{"offline", "TRANSCRIBER_OFFLINE", "never download models", func(c *Config) any { return &c.Offline }},
+	{"workers", "TRANSCRIBER_WORKERS", "number of concurrent worker processes", func(c *Config) any { return &c.Workers }},
+	{"threads", "TRANSCRIBER_THREADS", "threads per worker (0 splits the CPUs between workers)", func(c *Config) any { return &c.Threads }},
+	{"worker_max_jobs", "TRANSCRIBER_WORKER_MAX_JOBS", "jobs before a worker is recycled (0 = never)", func(c *Config) any { return &c.WorkerMaxJobs }},
+	{"worker_max_rss_mb", "TRANSCRIBER_WORKER_MAX_RSS_MB", "peak worker memory in MB before it is recycled (0 = unlimited)", func(c *Config) any { return &c.WorkerMaxRSSMB }},
+	{"open_browser", "", "open the web UI in a browser at startup", func(c *Config) any { return &c.OpenBrowser }},
+	{"webhook_url", "TRANSCRIBER_WEBHOOK_URL", "URL notified of every job's lifecycle events", func(c *Config) any { return &c.WebhookURL }},
+	{"webhook_secret", "TRANSCRIBER_WEBHOOK_SECRET", "key for the HMAC-SHA256 signature on webhook deliveries", func(c *Config) any { return &c.WebhookSecret }},
+	{"diarize_command", "TRANSCRIBER_DIARIZE_COMMAND", "command that prints the RTTM speaker turns of an audio file ({audio} is replaced by its path)", func(c *Config) any { return &c.DiarizeCommand }},
+}
+
+// secretSettings are masked when the configuration is printed
+var secretSettings = map[string]bool{"webhook_secret": true}
+
+// defaultConfig returns the built-in settings
+func defaultConfig() (*Config, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config directory: %w", err)
+	}
+
+	cfg := &Config{
+		Listen:          ":8456",
+		UploadDir:       filepath.Join(os.TempDir(), "transcriber-uploads"),
+		OutputDir:       defaultOutputDir(homeDir),
+		ModelDir:        filepath.Join(homeDir, ".cache", "whisper"),
+		StateDir:        filepath.Join(configDir, "transcriber-pro"),
+		MaxUploadMB:     20 * 1024, // 20GB
+		DefaultLanguage: "auto",
+		DefaultModel:    "large-v3",
+		ModelURL:        "https://huggingface.co/ggerganov/whisper.cpp/resolve/main",
+		Workers:         1,
+		WorkerMaxJobs:   50,
+		OpenBrowser:     true,
+		sources:         make(map[string]string),
+	}
+	for _, s := range configSettings {
+		cfg.sources[s.key] = "default"
+	}
+	return cfg, nil
+}
+
+// defaultOutputDir returns the platform-specific directory for saving
+// transcriptions
+func defaultOutputDir(homeDir string) string {
+	switch runtime.GOOS {
+	case "darwin":
+		// macOS: Use ~/.transcriber-pro
+		return filepath.Join(homeDir, ".transcriber-pro")
+	case "windows":
+		// Windows: Use executable directory/transcriptions
+		if exePath, err := os.Executable(); err == nil {
+			return filepath.Join(filepath.Dir(exePath), "transcriptions")
+		}
+		return filepath.Join(homeDir, "transcriptions")
+	default:
+		// Linux/Other: Use ~/transcriber-pro
+		return filepath.Join(homeDir, "transcriber-pro")
+	}
+}
+
+// defaultConfigFile is read when neither --config nor TRANSCRIBER_CONFIG is
+// given, if it exists
+func defaultConfigFile() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "transcriber-pro", "config.yaml")
+}
+
+// LoadConfig builds the effective configuration from the defaults, the config
+// file, the environment and the command-line flags in args. It returns
+// flag.ErrHelp if args asked for usage.
+func LoadConfig(args []string) (*Config, error) {
+	cfg, err := defaultConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Flags are parsed first to find --config, but applied last
+	type flagValue struct{ key, value string }
+	var flags []flagValue
+	configFile := os.Getenv("TRANSCRIBER_CONFIG")
+	explicit := configFile != ""
+
+	fs := flag.NewFlagSet("transcriber-pro", flag.ContinueOnError)
+	fs.Func("config", "config file (YAML)", func(value string) error {
+		configFile, explicit = value, true
+		return nil
+	})
+	fs.Func("port", "port to listen on (shorthand for --listen :PORT)", func(value string) error {
+		flags = append(flags, flagValue{"listen", ":" + value})
+		return nil
+	})
+	fs.BoolFunc("no-browser", "don't open the web UI at startup", func(value string) error {
+		off, err := strconv.ParseBool(value)
+		flags = append(flags, flagValue{"open_browser", strconv.FormatBool(!off)})
+		return err
+	})
+	var watchDirs []string
+	fs.Func("watch", "watch a folder for new recordings with default options (repeatable)", func(value string) error {
+		watchDirs = append(watchDirs, value)
+		return nil
+	})
+	for _, s := range configSettings {
+		key := s.key
+		set := func(value string) error {
+			flags = append(flags, flagValue{key, value})
+			return nil
+		}
+		name := strings.ReplaceAll(key, "_", "-")
+		if _, ok := s.field(cfg).(*bool); ok {
+			fs.BoolFunc(name, s.usage, set)
+		} else {
+			fs.Func(name, s.usage, set)
+		}
+	}
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: transcriber-pro [flags]")
+		fmt.Fprintln(fs.Output(), "       transcriber-pro models list|import ...")
+		fmt.Fprintln(fs.Output(), "       transcriber-pro transcribe [flags] <file|-> ...")
+		fmt.Fprintln(fs.Output(), "       transcriber-pro queue|submit|watch|kill|cancel|clear|export [--server URL] [--json] ...")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if !explicit {
+		configFile = defaultConfigFile()
+	}
+	if configFile != "" {
+		if err := cfg.loadFile(expandHome(configFile)); err != nil {
+			if explicit || !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	for _, f := range flags {
+		if err := cfg.set(f.key, f.value, "flag"); err != nil {
+			return nil, fmt.Errorf("--%s: %w", strings.ReplaceAll(f.key, "_", "-"), err)
+		}
+	}
+
+	for _, dir := range watchDirs {
+		cfg.WatchFolders = append(cfg.WatchFolders, WatchFolder{Path: dir})
+		cfg.sources["watch_folders"] = "flag"
+	}
+
+	cfg.UploadDir = expandHome(cfg.UploadDir)
+	cfg.OutputDir = expandHome(cfg.OutputDir)
+	cfg.ModelDir = expandHome(cfg.ModelDir)
+	cfg.StateDir = expandHome(cfg.StateDir)
+	for i := range cfg.WatchFolders {
+		if path := expandHome(cfg.WatchFolders[i].Path); path != "" {
+			if abs, err := filepath.Abs(path); err == nil {
+				path = abs
+			}
+			cfg.WatchFolders[i].Path = path
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile applies the settings present in a YAML config file
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var present map[string]any
+	if err := yaml.Unmarshal(data, &present); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for key := range present {
+		c.sources[key] = "file"
+	}
+	c.file = path
+	return nil
+}
+
+// loadEnv applies settings from environment variables. PORT and NO_BROWSER
+// are honored for compatibility with earlier releases.
+func (c *Config) loadEnv() error {
+	if port := os.Getenv("PORT"); port != "" {
+		c.set("listen", ":"+port, "env")
+	}
+	if os.Getenv("NO_BROWSER") != "" {
+		c.set("open_browser", "false", "env")
+	}
+
+	for _, s := range configSettings {
+		if s.env == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(s.env); ok && value != "" {
+			if err := c.set(s.key, value, "env"); err != nil {
+				return fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// set parses value into the setting named key
+func (c *Config) set(key, value, source string) error {
+	for _, s := range configSettings {
+		if s.key != key {
+			continue
+		}
+
+		switch field := s.field(c).(type) {
+		case *string:
+			*field = value
+		case *int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid number %q", value)
+			}
+			*field = n
+		case *int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid number %q", value)
+			}
+			*field = n
+		case *bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q", value)
+			}
+			*field = b
+		}
+		c.sources[key] = source
+		return nil
+	}
+	return fmt.Errorf("unknown setting %q", key)
+}
+
+func (c *Config) validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", c.Listen, err)
+	}
+	for _, dir := range []struct{ key, value string }{
+		{"upload_dir", c.UploadDir},
+		{"output_dir", c.OutputDir},
+		{"model_dir", c.ModelDir},
+		{"state_dir", c.StateDir},
+	} {
+		if dir.value == "" {
+			return fmt.Errorf("%s must not be empty", dir.key)
+		}
+	}
+	if c.MaxUploadMB <= 0 {
+		return fmt.Errorf("max_upload_mb must be positive")
+	}
+	if c.DefaultLanguage == "" {
+		return fmt.Errorf("default_language must not be empty")
+	}
+	if !validModelName(c.DefaultModel) {
+		return fmt.Errorf("invalid default_model %q", c.DefaultModel)
+	}
+	if c.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1")
+	}
+	if c.Threads < 0 {
+		return fmt.Errorf("threads must not be negative")
+	}
+	if c.WorkerMaxJobs < 0 {
+		return fmt.Errorf("worker_max_jobs must not be negative")
+	}
+	if c.WorkerMaxRSSMB < 0 {
+		return fmt.Errorf("worker_max_rss_mb must not be negative")
+	}
+	if c.WebhookURL != "" {
+		if err := validateWebhookURL(c.WebhookURL); err != nil {
+			return fmt.Errorf("webhook_url: %w", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, folder := range c.WatchFolders {
+		if folder.Path == "" {
+			return fmt.Errorf("watch_folders[%d]: path must not be empty", i)
+		}
+		if seen[folder.Path] {
+			return fmt.Errorf("watch_folders: %s is listed twice", folder.Path)
+		}
+		seen[folder.Path] = true
+		if folder.Model != "" && !validModelName(folder.Model) {
+			return fmt.Errorf("watch_folders[%d]: invalid model %q", i, folder.Model)
+		}
+		if len(folder.Formats) > 0 {
+			formats, err := parseFormats(strings.Join(folder.Formats, ","))
+			if err != nil {
+				return fmt.Errorf("watch_folders[%d]: %w", i, err)
+			}
+			c.WatchFolders[i].Formats = formats
+		}
+	}
+	return nil
+}
+
+// MaxUploadSize returns the upload limit in bytes
+func (c *Config) MaxUploadSize() int64 {
+	return c.MaxUploadMB * mb
+}
+
+// ServerURL returns the URL to open the web UI at
+func (c *Config) ServerURL() string {
+	host, port, _ := net.SplitHostPort(c.Listen)
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// File returns the config file that was loaded, or "" if none was
+func (c *Config) File() string {
+	return c.file
+}
+
+// Sources maps each setting to where its value came from: default, file,
+// env or flag
+func (c *Config) Sources() map[string]string {
+	sources := make(map[string]string, len(c.sources))
+	for key, source := range c.sources {
+		sources[key] = source
+	}
+	return sources
+}
+
+// Print writes the effective configuration, one setting per line
+func (c *Config) Print(w io.Writer) {
+	if c.file != "" {
+		fmt.Fprintf(w, "Config file: %s\n", c.file)
+	}
+
+	for _, s := range configSettings {
+		value := settingValue(s.field(c))
+		if secretSettings[s.key] && value != "" {
+			value = "********"
+		}
+		fmt.Fprintf(w, "  %-18s %v (%s)\n", s.key, value, c.sources[s.key])
+	}
+	for _, folder := range c.WatchFolders {
+		fmt.Fprintf(w, "  %-18s %s (%s)\n", "watch_folder", folder.Path, c.sources["watch_folders"])
+	}
+}
+
+// settingValue dereferences a setting's field pointer for printing
+func settingValue(field any) any {
+	switch v := field.(type) {
+	case *string:
+		return *v
+	case *int:
+		return *v
+	case *int64:
+		return *v
+	case *bool:
+		return *v
+	}
+	return field
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(homeDir, strings.TrimPrefix(path, "~"))
+}
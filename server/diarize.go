@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Speaker diarization is left to an external tool: either the command
+// configured as diarize_command, run on the job's audio, or an RTTM file
+// uploaded with the job. Its speaker turns are laid over the transcript
+// afterwards, splitting segments where the speaker changes.
+
+const (
+	// diarizeMinTimeout is how long the diarize command gets on short
+	// recordings; longer ones get twice their duration on top
+	diarizeMinTimeout = 10 * time.Minute
+
+	// rttmMergeGap is the silence, in seconds, across which consecutive
+	// segments of one speaker are exported as a single RTTM turn
+	rttmMergeGap = 0.5
+)
+
+// SpeakerTurn is a stretch of audio attributed to one speaker
+type SpeakerTurn struct {
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Speaker string  `json:"speaker"`
+}
+
+// parseRTTM reads the SPEAKER records of an RTTM file, sorted by start time.
+// Other record types, blank lines and comments are skipped.
+func parseRTTM(r io.Reader) ([]SpeakerTurn, error) {
+	var turns []SpeakerTurn
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, ";") || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// SPEAKER <file> <channel> <start> <duration> <NA> <NA> <name> <NA> <NA>
+		fields := strings.Fields(text)
+		if fields[0] != "SPEAKER" {
+			continue
+		}
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("rttm line %d: expected at least 8 fields, got %d", line, len(fields))
+		}
+		start, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil || math.IsNaN(start) || start < 0 || math.IsInf(start, 0) {
+			return nil, fmt.Errorf("rttm line %d: invalid start time %q", line, fields[3])
+		}
+		duration, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil || math.IsNaN(duration) || duration < 0 || math.IsInf(duration, 0) {
+			return nil, fmt.Errorf("rttm line %d: invalid duration %q", line, fields[4])
+		}
+		turns = append(turns, SpeakerTurn{Start: start, End: start + duration, Speaker: fields[7]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rttm: %w", err)
+	}
+	if len(turns) == 0 {
+		return nil, errors.New("rttm has no SPEAKER records")
+	}
+
+	sort.SliceStable(turns, func(i, j int) bool { return turns[i].Start < turns[j].Start })
+	return turns, nil
+}
+
+// readRTTMFile parses the RTTM file at path, or returns nil if path is empty
+func readRTTMFile(path string) ([]SpeakerTurn, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	turns, err := parseRTTM(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return turns, nil
+}
+
+// runDiarizeCommand runs the configured diarize command on audioPath and
+// parses the RTTM it prints. "{audio}" in the command is replaced by the
+// path; without it, the path is passed as the last argument. The command
+// runs without a shell and is killed when the job is cancelled.
+func (e *TranscriptionEngine) runDiarizeCommand(jobID, audioPath string, duration float64) ([]SpeakerTurn, error) {
+	args := strings.Fields(e.config.DiarizeCommand)
+	if len(args) == 0 {
+		return nil, errors.New("no diarize_command is configured")
+	}
+	substituted := false
+	for i, arg := range args {
+		if strings.Contains(arg, "{audio}") {
+			args[i] = strings.ReplaceAll(arg, "{audio}", audioPath)
+			substituted = true
+		}
+	}
+	if !substituted {
+		args = append(args, audioPath)
+	}
+
+	timeout := diarizeMinTimeout + time.Duration(2*duration)*time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Stop the command as soon as the job is cancelled or killed
+	go func() {
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if e.IsCancelled(jobID) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("diarize command timed out after %s", timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("diarize command failed: %v: %s", err, lastLine(msg))
+		}
+		return nil, fmt.Errorf("diarize command failed: %w", err)
+	}
+	return parseRTTM(&stdout)
+}
+
+// lastLine returns the last line of s, which is where tools usually put
+// the error that made them exit
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// assignSpeakers labels the transcript with the speakers of turns. Every
+// word goes to the speaker whose turns overlap it the most, and segments are
+// split where the speaker changes. Words without decoder timings get times
+// interpolated from their segment's, and are then dropped again.
+func assignSpeakers(segments []TranscriptionSegment, turns []SpeakerTurn) []TranscriptionSegment {
+	if len(turns) == 0 {
+		return segments
+	}
+
+	var out []TranscriptionSegment
+	for _, segment := range segments {
+		words := splitWords([]TranscriptionSegment{segment})
+		if len(words) == 0 {
+			segment.Speaker = speakerAt(turns, segment.Start, segment.End, "")
+			out = append(out, segment)
+			continue
+		}
+
+		// Runs of consecutive words with the same speaker
+		var runs [][]timedWord
+		previous := ""
+		for _, word := range words {
+			word.Speaker = speakerAt(turns, word.Start, word.End, previous)
+			if len(runs) == 0 || word.Speaker != previous {
+				runs = append(runs, nil)
+			}
+			runs[len(runs)-1] = append(runs[len(runs)-1], word)
+			previous = word.Speaker
+		}
+
+		if len(runs) == 1 {
+			segment.Speaker = runs[0][0].Speaker
+			out = append(out, segment)
+			continue
+		}
+		for i, run := range runs {
+			part := TranscriptionSegment{
+				Start:   run[0].Start,
+				End:     run[len(run)-1].End,
+				Text:    joinWords(run),
+				Speaker: run[0].Speaker,
+			}
+			if strings.HasPrefix(segment.Text, " ") {
+				// Whisper starts segments with the space before their first word
+				part.Text = " " + part.Text
+			}
+			// Keep the segment's own bounds at its ends
+			if i == 0 {
+				part.Start = segment.Start
+			}
+			if i == len(runs)-1 {
+				part.End = segment.End
+			}
+			if len(segment.Words) > 0 {
+				for _, word := range run {
+					part.Words = append(part.Words, word.TranscriptionWord)
+				}
+			}
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// speakerAt returns the speaker whose turns overlap start to end the most.
+// If none does, it keeps previous, or failing that takes the nearest turn.
+func speakerAt(turns []SpeakerTurn, start, end float64, previous string) string {
+	overlap := make(map[string]float64)
+	best := ""
+	for _, turn := range turns {
+		if turn.Start >= end {
+			break
+		}
+		if o := math.Min(end, turn.End) - math.Max(start, turn.Start); o > 0 {
+			overlap[turn.Speaker] += o
+			if best == "" || overlap[turn.Speaker] > overlap[best] {
+				best = turn.Speaker
+			}
+		}
+	}
+	if best != "" {
+		return best
+	}
+	if previous != "" {
+		return previous
+	}
+
+	distance := math.Inf(1)
+	for _, turn := range turns {
+		d := math.Max(turn.Start-end, start-turn.End)
+		if d < distance {
+			distance = d
+			best = turn.Speaker
+		}
+	}
+	return best
+}
+
+// renderRTTM writes the speaker timeline of a result as RTTM. Consecutive
+// segments of one speaker less than rttmMergeGap apart become one turn.
+// Segments without a speaker are left out.
+func renderRTTM(result *TranscriptionResult, opts ExportOptions) ([]byte, error) {
+	file := strings.TrimSuffix(filepath.Base(opts.Source), filepath.Ext(opts.Source))
+	file = strings.Join(strings.Fields(file), "_")
+	if file == "" || file == "." {
+		file = "audio"
+	}
+
+	var turns []SpeakerTurn
+	for _, segment := range result.Segments {
+		if segment.Speaker == "" {
+			continue
+		}
+		if n := len(turns); n > 0 && turns[n-1].Speaker == segment.Speaker && segment.Start-turns[n-1].End < rttmMergeGap {
+			turns[n-1].End = math.Max(turns[n-1].End, segment.End)
+			continue
+		}
+		turns = append(turns, SpeakerTurn{Start: segment.Start, End: segment.End, Speaker: segment.Speaker})
+	}
+
+	return []byte(formatRTTM(file, turns)), nil
+}
+
+// formatRTTM writes turns as RTTM SPEAKER records for file
+func formatRTTM(file string, turns []SpeakerTurn) string {
+	var b strings.Builder
+	for _, turn := range turns {
+		// RTTM fields are space separated, so names can't contain spaces
+		speaker := strings.Join(strings.Fields(turn.Speaker), "_")
+		fmt.Fprintf(&b, "SPEAKER %s 1 %.3f %.3f <NA> <NA> %s <NA> <NA>\n", file, turn.Start, turn.End-turn.Start, speaker)
+	}
+	return b.String()
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Download states reported by DownloadStatus
+const (
+	DownloadRunning   = "downloading"
+	DownloadVerifying = "verifying"
+	DownloadCompleted = "completed"
+	DownloadFailed    = "failed"
+)
+
+const (
+	downloadAttempts  = 5
+	downloadRetryWait = 2 * time.Second
+
+	// A stalled connection fails after these and the download is retried,
+	// rather than hanging forever: the first waits for response headers, the
+	// second for each read of the body
+	downloadHeaderTimeout = 30 * time.Second
+	downloadIdleTimeout   = 60 * time.Second
+)
+
+// ErrDownloadInProgress is returned when a model is already being downloaded
+var ErrDownloadInProgress = errors.New("download already in progress")
+
+// ErrNoChecksum is returned for downloads without a known SHA-256; nothing is
+// installed that can't be verified
+var ErrNoChecksum = errors.New("no known checksum")
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// DownloadStatus is the progress of a single model download
+type DownloadStatus struct {
+	Model       string    `json:"model"`
+	State       string    `json:"state"`
+	Downloaded  int64     `json:"downloaded"`
+	Total       int64     `json:"total"` // 0 while unknown
+	Percent     float64   `json:"percent"`
+	ResumedFrom int64     `json:"resumed_from,omitempty"` // Bytes already on disk when the download (re)started
+	SHA256      string    `json:"sha256,omitempty"`
+	Verified    bool      `json:"verified"` // Checksum matched the expected value
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at,omitempty"`
+}
+
+// Downloader fetches model files over HTTP. Data is written to a .part file
+// next to the destination, interrupted downloads resume with a range request
+// conditional on the file being unchanged, and the file is only renamed into
+// place once its SHA-256 has been checked.
+type Downloader struct {
+	client      *http.Client
+	idleTimeout time.Duration
+	retryWait   time.Duration // Grows with each attempt
+	mu          sync.Mutex
+	downloads   map[string]*DownloadStatus // Latest download per model name
+}
+
+func NewDownloader() *Downloader {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = downloadHeaderTimeout
+
+	return &Downloader{
+		client:      &http.Client{Transport: transport},
+		idleTimeout: downloadIdleTimeout,
+		retryWait:   downloadRetryWait,
+		downloads:   make(map[string]*DownloadStatus),
+	}
+}
+
+// Status returns a snapshot of every download started since the server came
+// up
+func (d *Downloader) Status() []DownloadStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	statuses := make([]DownloadStatus, 0, len(d.downloads))
+	for _, status := range d.downloads {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].StartedAt.Before(statuses[j].StartedAt)
+	})
+	return statuses
+}
+
+// IsRunning reports whether a download for the model is in progress
+func (d *Downloader) IsRunning(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status, ok := d.downloads[name]
+	return ok && (status.State == DownloadRunning || status.State == DownloadVerifying)
+}
+
+func (d *Downloader) update(name string, fn func(*DownloadStatus)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if status, ok := d.downloads[name]; ok {
+		fn(status)
+		if status.Total > 0 {
+			status.Percent = float64(status.Downloaded) / float64(status.Total) * 100
+		}
+	}
+}
+
+// Download fetches url into dest and returns the SHA-256 of the file, which
+// must match expectedSHA; without one it fails with ErrNoChecksum. A checksum
+// advertised by the server (Hugging Face's X-Linked-Etag) is only used as a
+// cross-check. Transient failures are retried, resuming from the bytes already
+// downloaded.
+func (d *Downloader) Download(ctx context.Context, name, url, dest, expectedSHA string) (string, error) {
+	d.mu.Lock()
+	if status, ok := d.downloads[name]; ok && (status.State == DownloadRunning || status.State == DownloadVerifying) {
+		d.mu.Unlock()
+		return "", fmt.Errorf("%w: %s", ErrDownloadInProgress, name)
+	}
+	d.downloads[name] = &DownloadStatus{Model: name, State: DownloadRunning, StartedAt: time.Now()}
+	d.mu.Unlock()
+
+	var sum string
+	var err error
+	expectedSHA = strings.ToLower(expectedSHA)
+	if sha256Pattern.MatchString(expectedSHA) {
+		sum, err = d.download(ctx, name, url, dest, expectedSHA)
+	} else if expectedSHA == "" {
+		err = fmt.Errorf("%w for model %s", ErrNoChecksum, name)
+	} else {
+		err = fmt.Errorf("invalid SHA-256 %q for model %s", expectedSHA, name)
+	}
+
+	d.update(name, func(status *DownloadStatus) {
+		status.FinishedAt = time.Now()
+		if err != nil {
+			status.State = DownloadFailed
+			status.Error = err.Error()
+			return
+		}
+		status.State = DownloadCompleted
+		status.SHA256 = sum
+		status.Verified = true
+	})
+
+	return sum, err
+}
+
+func (d *Downloader) download(ctx context.Context, name, url, dest, expectedSHA string) (string, error) {
+	partPath := dest + ".part"
+
+	var lastErr error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if attempt > 1 {
+			wait := d.retryWait * time.Duration(attempt-1)
+			log.Printf("[Download %s] Attempt %d failed: %v; retrying in %s", name, attempt-1, lastErr, wait)
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		advertised, err := d.fetch(ctx, name, url, partPath)
+		if err != nil {
+			lastErr = err
+			var permanent *permanentError
+			if errors.As(err, &permanent) || ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		if advertised != "" && advertised != expectedSHA {
+			// The server is sending some other file, so the .part is no use
+			removePart(partPath)
+			return "", fmt.Errorf("server advertises SHA-256 %s, expected %s", advertised, expectedSHA)
+		}
+		return d.finish(name, partPath, dest, expectedSHA)
+	}
+
+	// The .part file is kept so the next attempt resumes where this one stopped
+	return "", fmt.Errorf("download failed: %w", lastErr)
+}
+
+// permanentError marks a failure retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// fetch appends the rest of the file to partPath, resuming from its current
+// size. It returns the checksum advertised by the server, if any.
+func (d *Downloader) fetch(ctx context.Context, name, url, partPath string) (string, error) {
+	var offset int64
+	if stat, err := os.Stat(partPath); err == nil {
+		offset = stat.Size()
+	}
+
+	// Cancelled when the body stalls for longer than idleTimeout
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", &permanentError{err}
+	}
+	if offset > 0 {
+		// Only resume if the server's file is still the one the .part came
+		// from; otherwise it answers If-Range with the whole file
+		if validator := readValidator(partPath); validator != "" {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", validator)
+		} else {
+			log.Printf("[Download %s] No ETag or Last-Modified saved for the partial download; starting over", name)
+		}
+	}
+
+	// Hugging Face puts the SHA-256 of LFS files on the redirect to its CDN
+	var advertised string
+	client := *d.client
+	client.CheckRedirect = func(redirect *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if redirect.Response != nil {
+			if sum := advertisedSHA(redirect.Response.Header); sum != "" {
+				advertised = sum
+			}
+		}
+		return nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if sum := advertisedSHA(resp.Header); sum != "" {
+		advertised = sum
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	var total int64
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+		total = contentRangeTotal(resp.Header.Get("Content-Range"))
+		log.Printf("[Download %s] Resuming at %d bytes", name, offset)
+	case http.StatusOK:
+		// The server ignored the range, the file changed, or there was no
+		// range, so start over
+		flags |= os.O_TRUNC
+		offset = 0
+		total = resp.ContentLength
+		if err := writeValidator(partPath, resp.Header); err != nil {
+			return "", &permanentError{err}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The .part file already holds the whole file
+		return advertised, nil
+	default:
+		err := fmt.Errorf("unexpected HTTP status %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return "", &permanentError{err}
+		}
+		return "", err
+	}
+
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return "", &permanentError{err}
+	}
+	defer file.Close()
+
+	d.update(name, func(status *DownloadStatus) {
+		status.State = DownloadRunning
+		status.Downloaded = offset
+		status.ResumedFrom = offset
+		status.Total = total
+	})
+
+	var stalled atomic.Bool
+	idle := time.AfterFunc(d.idleTimeout, func() {
+		stalled.Store(true)
+		cancel()
+	})
+	defer idle.Stop()
+	body := &idleReader{r: resp.Body, timer: idle, timeout: d.idleTimeout}
+
+	progress := &progressWriter{name: name, d: d, written: offset, total: total}
+	_, err = io.Copy(io.MultiWriter(file, progress), body)
+	d.update(name, func(status *DownloadStatus) {
+		status.Downloaded = progress.written
+	})
+	if err != nil {
+		if stalled.Load() {
+			return "", fmt.Errorf("no data received for %s", d.idleTimeout)
+		}
+		return "", err
+	}
+	if err := file.Sync(); err != nil {
+		return "", err
+	}
+
+	if total > 0 && progress.written < total {
+		return "", fmt.Errorf("connection closed after %d of %d bytes", progress.written, total)
+	}
+
+	return advertised, nil
+}
+
+// finish verifies the downloaded file and atomically moves it into place
+func (d *Downloader) finish(name, partPath, dest, expectedSHA string) (string, error) {
+	d.update(name, func(status *DownloadStatus) {
+		status.State = DownloadVerifying
+	})
+
+	sum, err := fileSHA256(partPath)
+	if err != nil {
+		return "", err
+	}
+	if sum != expectedSHA {
+		// The data is corrupt, so don't resume from it next time
+		removePart(partPath)
+		return "", fmt.Errorf("checksum mismatch: got %s, expected %s", sum, expectedSHA)
+	}
+
+	if err := checkModelFile(partPath); err != nil {
+		removePart(partPath)
+		return "", err
+	}
+
+	if err := os.Rename(partPath, dest); err != nil {
+		return "", fmt.Errorf("failed to move model into place: %w", err)
+	}
+	os.Remove(validatorPath(partPath))
+
+	log.Printf("[Download %s] Complete (SHA-256 %s)", name, sum)
+	return sum, nil
+}
+
+// removePart deletes a partial download and its validator
+func removePart(partPath string) {
+	os.Remove(partPath)
+	os.Remove(validatorPath(partPath))
+}
+
+// validatorPath is where the ETag or Last-Modified of the file a .part
+// belongs to is kept, so a resume across restarts can send If-Range
+func validatorPath(partPath string) string {
+	return partPath + ".validator"
+}
+
+func readValidator(partPath string) string {
+	data, err := os.ReadFile(validatorPath(partPath))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// writeValidator saves the response's strong ETag, or failing that its
+// Last-Modified, as the validator of the .part. Weak ETags can't be used with
+// If-Range.
+func writeValidator(partPath string, header http.Header) error {
+	validator := header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		err := os.Remove(validatorPath(partPath))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(validatorPath(partPath), []byte(validator+"\n"), 0644)
+}
+
+// idleReader pushes back timer by timeout on every read, so it only fires
+// once the body has stalled
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.timer.Reset(r.timeout)
+	return n, err
+}
+
+// progressWriter counts bytes as they are written and publishes progress,
+// logging roughly every 5%
+type progressWriter struct {
+	name      string
+	d         *Downloader
+	written   int64
+	total     int64
+	lastLog   float64
+	lastPrint time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+
+	if time.Since(p.lastPrint) < 250*time.Millisecond {
+		return len(b), nil
+	}
+	p.lastPrint = time.Now()
+
+	p.d.update(p.name, func(status *DownloadStatus) {
+		status.Downloaded = p.written
+	})
+
+	if p.total > 0 {
+		percent := float64(p.written) / float64(p.total) * 100
+		if percent-p.lastLog >= 5 {
+			p.lastLog = percent
+			log.Printf("[Download %s] %.0f%% (%d / %d MB)", p.name, percent, p.written/mb, p.total/mb)
+		}
+	}
+	return len(b), nil
+}
+
+// advertisedSHA extracts a SHA-256 from Hugging Face's X-Linked-Etag header
+func advertisedSHA(header http.Header) string {
+	etag := strings.ToLower(strings.Trim(header.Get("X-Linked-Etag"), `"`))
+	if sha256Pattern.MatchString(etag) {
+		return etag
+	}
+	return ""
+}
+
+// contentRangeTotal parses the total size from "bytes start-end/total"
+func contentRangeTotal(header string) int64 {
+	_, totalStr, ok := strings.Cut(header, "/")
+	if !ok {
+		return 0
+	}
+	total, err := strconv.ParseInt(totalStr, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return total
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
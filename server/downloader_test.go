@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testModel returns a fake ggml model file and its SHA-256
+func testModel(t *testing.T, size int) ([]byte, string) {
+	t.Helper()
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint32(data, ggmlMagic)
+	for i := 4; i < size; i++ {
+		data[i] = byte(i * 7)
+	}
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:])
+}
+
+func testDownloader() *Downloader {
+	d := NewDownloader()
+	d.retryWait = 10 * time.Millisecond
+	d.idleTimeout = 200 * time.Millisecond
+	return d
+}
+
+// serveModel serves data with an ETag, honouring Range and If-Range
+func serveModel(w http.ResponseWriter, r *http.Request, data []byte) {
+	w.Header().Set("ETag", `"v1"`)
+	http.ServeContent(w, r, "model.bin", time.Time{}, bytes.NewReader(data))
+}
+
+func TestDownloadResumesAfterCutConnection(t *testing.T) {
+	data, sum := testModel(t, 64*1024)
+
+	var mu sync.Mutex
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r)
+		first := len(requests) == 1
+		mu.Unlock()
+
+		if first {
+			// Send half the file, then drop the connection
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", "65536")
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		serveModel(w, r, data)
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+	d := testDownloader()
+	got, err := d.Download(context.Background(), "test", server.URL, dest, sum)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got != sum {
+		t.Errorf("sum = %s, want %s", got, sum)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	if r := requests[1].Header.Get("Range"); r != "bytes=32768-" {
+		t.Errorf("Range = %q, want bytes=32768-", r)
+	}
+	if r := requests[1].Header.Get("If-Range"); r != `"v1"` {
+		t.Errorf("If-Range = %q, want the ETag", r)
+	}
+
+	installed, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(installed, data) {
+		t.Error("installed file differs from the served one")
+	}
+	status := d.Status()[0]
+	if status.State != DownloadCompleted || !status.Verified || status.ResumedFrom != 32768 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestDownloadRestartsWhenFileChanged(t *testing.T) {
+	data, sum := testModel(t, 16*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveModel(w, r, data)
+	}))
+	defer server.Close()
+
+	// A .part left over from an older version of the file
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+	os.WriteFile(dest+".part", bytes.Repeat([]byte{1}, 4096), 0644)
+	os.WriteFile(validatorPath(dest+".part"), []byte(`"v0"`), 0644)
+
+	if _, err := testDownloader().Download(context.Background(), "test", server.URL, dest, sum); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	installed, _ := os.ReadFile(dest)
+	if !bytes.Equal(installed, data) {
+		t.Error("installed file differs from the served one")
+	}
+}
+
+func TestDownloadRetriesStalledConnection(t *testing.T) {
+	data, sum := testModel(t, 16*1024)
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", "16384")
+			w.Write(data[:1024])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		serveModel(w, r, data)
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+	if _, err := testDownloader().Download(context.Background(), "test", server.URL, dest, sum); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	data, _ := testModel(t, 16*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveModel(w, r, data)
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+	d := testDownloader()
+	_, err := d.Download(context.Background(), "test", server.URL, dest, strings.Repeat("0", 64))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("err = %v, want a checksum mismatch", err)
+	}
+
+	for _, path := range []string{dest, dest + ".part", validatorPath(dest + ".part")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s exists after a checksum mismatch", filepath.Base(path))
+		}
+	}
+	if status := d.Status()[0]; status.State != DownloadFailed || status.Verified {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestDownloadAdvertisedChecksumMismatch(t *testing.T) {
+	data, sum := testModel(t, 16*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Linked-Etag", `"`+strings.Repeat("a", 64)+`"`)
+		serveModel(w, r, data)
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+	_, err := testDownloader().Download(context.Background(), "test", server.URL, dest, sum)
+	if err == nil || !strings.Contains(err.Error(), "advertises") {
+		t.Fatalf("err = %v, want an advertised checksum mismatch", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("model installed despite the advertised checksum mismatch")
+	}
+}
+
+func TestDownloadRequiresChecksum(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("downloaded without a checksum")
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+	d := testDownloader()
+	if _, err := d.Download(context.Background(), "test", server.URL, dest, ""); err == nil {
+		t.Fatal("Download succeeded without a checksum")
+	}
+	if status := d.Status()[0]; status.State != DownloadFailed {
+		t.Errorf("state = %s, want failed", status.State)
+	}
+}
+
+func TestDownloadCompletePart(t *testing.T) {
+	data, sum := testModel(t, 16*1024)
+
+	var status atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveModel(&statusRecorder{ResponseWriter: w, status: &status}, r, data)
+	}))
+	defer server.Close()
+
+	// The previous run received every byte but stopped before renaming
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+	os.WriteFile(dest+".part", data, 0644)
+	os.WriteFile(validatorPath(dest+".part"), []byte(`"v1"`), 0644)
+
+	if _, err := testDownloader().Download(context.Background(), "test", server.URL, dest, sum); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if s := status.Load(); s != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("server answered %d, want 416", s)
+	}
+	installed, _ := os.ReadFile(dest)
+	if !bytes.Equal(installed, data) {
+		t.Error("installed file differs from the served one")
+	}
+}
+
+func TestDownloadRenamesIntoPlace(t *testing.T) {
+	data, sum := testModel(t, 16*1024)
+	dest := filepath.Join(t.TempDir(), "ggml-test.bin")
+
+	var destExisted atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := os.Stat(dest); err == nil {
+			destExisted.Store(true)
+		}
+		serveModel(w, r, data)
+	}))
+	defer server.Close()
+
+	if _, err := testDownloader().Download(context.Background(), "test", server.URL, dest, sum); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if destExisted.Load() {
+		t.Error("destination existed while the download was running")
+	}
+	installed, _ := os.ReadFile(dest)
+	if !bytes.Equal(installed, data) {
+		t.Error("installed file differs from the served one")
+	}
+	for _, path := range []string{dest + ".part", validatorPath(dest + ".part")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after the download", filepath.Base(path))
+		}
+	}
+}
+
+// statusRecorder records the status code a handler answers with
+type statusRecorder struct {
+	http.ResponseWriter
+	status *atomic.Int32
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status.Store(int32(status))
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// subscriberBuffer is how many messages a slow client may fall behind
+	// before it is disconnected; it resyncs from a fresh snapshot when it
+	// reconnects
+	subscriberBuffer = 256
+
+	eventKeepalive = 15 * time.Second
+)
+
+// StreamMessage is one message on the event stream. The first message on
+// every stream is a "snapshot"; after that, Job holds only the fields of
+// the job that changed.
+type StreamMessage struct {
+	Type    string                 `json:"type"`
+	Seq     uint64                 `json:"seq"` // Seq of the job event; for a snapshot, of the last event it includes
+	JobID   string                 `json:"job_id,omitempty"`
+	Job     map[string]interface{} `json:"job,omitempty"`
+	Segment *TranscriptionSegment  `json:"segment,omitempty"`
+
+	// Snapshot of the whole queue, in the same shape as GET /queue
+	Queue     []Job `json:"queue,omitempty"`
+	Completed []Job `json:"completed,omitempty"`
+}
+
+// jobState is the part of a job the broker diffs between events
+type jobState struct {
+	Status        JobStatus
+	Progress      float64
+	Message       string
+	ETA           string
+	QueuePosition int
+	Error         string
+}
+
+// eventSubscriber is one connected stream client
+type eventSubscriber struct {
+	jobID string // Only this job's events, or all jobs if empty
+	ch    chan StreamMessage
+}
+
+// EventBroker turns engine job events into deltas and fans them out to the
+// connected SSE and WebSocket clients.
+type EventBroker struct {
+	mu     sync.Mutex
+	subs   map[*eventSubscriber]bool
+	last   map[string]jobState // Last state published per job
+	closed bool
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subs: make(map[*eventSubscriber]bool),
+		last: make(map[string]jobState),
+	}
+}
+
+// Publish is registered with TranscriptionEngine.OnJobEvent. It never
+// blocks: a client whose buffer is full is disconnected.
+func (b *EventBroker) Publish(event JobEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msg, ok := b.deltaLocked(event)
+	if !ok {
+		return
+	}
+
+	for sub := range b.subs {
+		if sub.jobID != "" && sub.jobID != msg.JobID {
+			continue
+		}
+		select {
+		case sub.ch <- msg:
+		default:
+			log.Printf("[Events] Dropping a client that fell behind")
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// deltaLocked builds the message for event with only the fields that changed
+// since the job's last message. It returns false if nothing changed.
+func (b *EventBroker) deltaLocked(event JobEvent) (StreamMessage, bool) {
+	job := &event.Job
+	msg := StreamMessage{Type: event.Type, Seq: event.Seq, JobID: job.ID}
+
+	switch event.Type {
+	case JobEventRemoved:
+		delete(b.last, job.ID)
+		return msg, true
+	case JobEventSegment:
+		msg.Segment = event.Segment
+		return msg, true
+	}
+
+	prev, known := b.last[job.ID]
+	cur := jobState{job.Status, job.Progress, job.Message, job.ETA, job.QueuePosition, job.Error}
+	b.last[job.ID] = cur
+
+	delta := make(map[string]interface{})
+	if !known || event.Type == JobEventQueued {
+		// First time the broker sees this job: send all of it
+		delta["ID"] = job.ID
+		delta["FileName"] = job.FileName
+		delta["CreatedAt"] = job.CreatedAt
+		delta["Language"] = job.Language
+		delta["Model"] = job.Model
+		prev = jobState{Progress: -1, QueuePosition: -1}
+	}
+	if cur.Status != prev.Status {
+		delta["Status"] = cur.Status
+	}
+	if cur.Progress != prev.Progress {
+		delta["Progress"] = cur.Progress
+	}
+	if cur.Message != prev.Message {
+		delta["Message"] = cur.Message
+	}
+	if cur.ETA != prev.ETA {
+		delta["ETA"] = cur.ETA
+	}
+	if cur.QueuePosition != prev.QueuePosition {
+		delta["QueuePosition"] = cur.QueuePosition
+	}
+	if cur.Error != prev.Error {
+		delta["Error"] = cur.Error
+	}
+	if event.Type == JobEventCompleted && job.Result != nil {
+		delta["Result"] = job.Result
+	}
+
+	if len(delta) == 0 {
+		return msg, false
+	}
+	msg.Job = delta
+	return msg, true
+}
+
+// Subscribe registers a client for all jobs, or only jobID if it isn't
+// empty. The returned channel is closed if the client falls behind.
+func (b *EventBroker) Subscribe(jobID string) *eventSubscriber {
+	sub := &eventSubscriber{jobID: jobID, ch: make(chan StreamMessage, subscriberBuffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.ch)
+	} else {
+		b.subs[sub] = true
+	}
+	return sub
+}
+
+// Unsubscribe removes a client
+func (b *EventBroker) Unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Close ends every stream so the HTTP server can shut down
+func (b *EventBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// snapshot returns the first message of a stream. Subscribe must be called
+// first, so no change falls between the snapshot and the deltas; deltas the
+// snapshot already includes are skipped by comparing Seq (see stale).
+func snapshot(jobID string) (StreamMessage, bool) {
+	if jobID == "" {
+		queued, completed, seq := engine.QueueSnapshot()
+		return StreamMessage{Type: "snapshot", Seq: seq, Queue: queued, Completed: completed}, true
+	}
+
+	job, seq := engine.JobSnapshot(jobID)
+	if job == nil {
+		return StreamMessage{}, false
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return StreamMessage{}, false
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	if job.Partial != nil {
+		// Let a client joining mid-transcription catch up on the text so far
+		fields["Partial"] = job.Partial
+	}
+	return StreamMessage{Type: "snapshot", Seq: seq, JobID: jobID, Job: fields}, true
+}
+
+// stale reports whether msg was published before snapshot was taken, so the
+// snapshot already includes it. Such deltas can be waiting in a subscriber's
+// channel, since it subscribes before taking the snapshot.
+func stale(msg, snapshot StreamMessage) bool {
+	return msg.Seq <= snapshot.Seq
+}
+
+// handleEvents streams job updates as Server-Sent Events. ?job=<id> limits
+// the stream to one job.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendJSONError(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	jobID := r.URL.Query().Get("job")
+	sub := broker.Subscribe(jobID)
+	defer broker.Unsubscribe(sub)
+
+	first, ok := snapshot(jobID)
+	if !ok {
+		sendJSONError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	writeEvent := func(msg StreamMessage) error {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	if err := writeEvent(first); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if stale(msg, first) {
+				continue
+			}
+			if err := writeEvent(msg); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// upgrader refuses cross-origin WebSocket requests; the web UI is served from
+// the same origin
+var upgrader = websocket.Upgrader{}
+
+// handleWebSocket streams the same messages as handleEvents over a
+// WebSocket, one JSON message per frame
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	jobID := r.URL.Query().Get("job")
+	sub := broker.Subscribe(jobID)
+	defer broker.Unsubscribe(sub)
+
+	first, ok := snapshot(jobID)
+	if !ok {
+		sendJSONError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Read until the client goes away; clients never send anything we need
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := conn.WriteJSON(first); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case msg, ok := <-sub.ch:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client fell behind"))
+				return
+			}
+			if stale(msg, first) {
+				continue
+			}
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,628 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// exportFormat renders a finished transcription as one kind of file
+type exportFormat struct {
+	ContentType string
+	Render      func(result *TranscriptionResult, opts ExportOptions) ([]byte, error)
+}
+
+// ExportOptions adjust how a result is rendered. The zero value gives every
+// format's defaults.
+type ExportOptions struct {
+	VTT           VTTOptions
+	Timestamps    bool           // Start every segment of TXT and Markdown with its start time
+	MaxLineLength int            // Wrap TXT, Markdown and subtitle text at this many characters; 0 leaves lines as they are
+	Offset        float64        // Seconds added to every timestamp, e.g. to line up with an edited recording
+	Subtitles     *SubtitleStyle // Reflow SRT and WebVTT cues; nil keeps one cue per segment
+	JobID         string         // Job the result belongs to, for the WebVTT NOTE block
+	Source        string         // Original file name, for the WebVTT NOTE block, Markdown title and RTTM file ID
+}
+
+// exportFormats maps a format name, which is also the file extension, to its
+// renderer. The server, the transcribe command and the API all export through
+// this table.
+var exportFormats = map[string]exportFormat{
+	"txt":  {"text/plain; charset=utf-8", renderTXT},
+	"json": {"application/json", renderJSON},
+	"srt":  {"application/x-subrip; charset=utf-8", renderSRT},
+	"vtt":  {"text/vtt; charset=utf-8", renderVTT},
+	"tsv":  {"text/tab-separated-values; charset=utf-8", renderTSV},
+	"csv":  {"text/csv; charset=utf-8", renderCSV},
+	"md":   {"text/markdown; charset=utf-8", renderMarkdown},
+	"rttm": {"text/plain; charset=utf-8", renderRTTM},
+}
+
+// exportFormatNames lists the formats in the order they are documented
+var exportFormatNames = []string{"txt", "json", "srt", "vtt", "tsv", "csv", "md", "rttm"}
+
+// zipFormat bundles every format in one archive. It is only offered by the
+// export endpoint, so it isn't in exportFormats.
+const zipFormat = "zip"
+
+// savedFormats are written to the output directory for every finished job
+var savedFormats = []string{"txt", "json", "srt", "vtt"}
+
+// parseFormats splits a comma-separated format list such as "srt,vtt",
+// dropping duplicates. "all" selects every format.
+func parseFormats(list string) ([]string, error) {
+	var formats []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			return exportFormatNames, nil
+		}
+		if _, ok := exportFormats[name]; !ok {
+			return nil, fmt.Errorf("unknown format %q (supported: %s)", name, strings.Join(exportFormatNames, ", "))
+		}
+		if !seen[name] {
+			seen[name] = true
+			formats = append(formats, name)
+		}
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no format given")
+	}
+	return formats, nil
+}
+
+// renderExport renders result in the named format
+func renderExport(result *TranscriptionResult, format string, opts ExportOptions) ([]byte, error) {
+	f, ok := exportFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return f.Render(opts.shift(result), opts)
+}
+
+// renderZIP bundles result in every format as base.<format>
+func renderZIP(result *TranscriptionResult, base string, opts ExportOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, format := range exportFormatNames {
+		data, err := renderExport(result, format, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", strings.ToUpper(format), err)
+		}
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: base + "." + format, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// shift returns result with opts.Offset added to every timestamp. Times
+// that would become negative are clamped to zero.
+func (o ExportOptions) shift(result *TranscriptionResult) *TranscriptionResult {
+	if o.Offset == 0 {
+		return result
+	}
+	move := func(t float64) float64 { return math.Max(0, t+o.Offset) }
+
+	shifted := *result
+	shifted.Segments = make([]TranscriptionSegment, len(result.Segments))
+	for i, segment := range result.Segments {
+		segment.Start = move(segment.Start)
+		segment.End = move(segment.End)
+		if segment.Words != nil {
+			words := make([]TranscriptionWord, len(segment.Words))
+			for j, word := range segment.Words {
+				word.Start = move(word.Start)
+				word.End = move(word.End)
+				words[j] = word
+			}
+			segment.Words = words
+		}
+		shifted.Segments[i] = segment
+	}
+	return &shifted
+}
+
+// parseExportOptions reads the export options from query parameters:
+// timestamps, max_line_length, offset and the WebVTT cue settings
+func parseExportOptions(query url.Values) (ExportOptions, error) {
+	var opts ExportOptions
+	var err error
+
+	if opts.VTT, err = parseVTTOptions(query); err != nil {
+		return opts, err
+	}
+	if value := query.Get("timestamps"); value != "" {
+		if opts.Timestamps, err = strconv.ParseBool(value); err != nil {
+			return opts, fmt.Errorf("invalid timestamps %q (true or false)", value)
+		}
+	}
+	if value := query.Get("max_line_length"); value != "" {
+		if opts.MaxLineLength, err = strconv.Atoi(value); err != nil || opts.MaxLineLength < 0 {
+			return opts, fmt.Errorf("invalid max_line_length %q (a number of characters, 0 for no wrapping)", value)
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if opts.Offset, err = strconv.ParseFloat(value, 64); err != nil || math.IsNaN(opts.Offset) || math.IsInf(opts.Offset, 0) {
+			return opts, fmt.Errorf("invalid offset %q (seconds, e.g. 12.5 or -3)", value)
+		}
+	}
+	if opts.Subtitles, err = parseSubtitleStyle(query); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// exportFileName returns the download name for a job's transcript: the
+// uploaded file's name with the format's extension
+func exportFileName(fileName, format string) string {
+	base := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	if base == "" || base == "." || base == string(filepath.Separator) {
+		base = "transcript"
+	}
+	return base + "." + format
+}
+
+// writeExport renders result in the named format to dir/base.<format> and
+// returns the path written
+func writeExport(dir, base string, result *TranscriptionResult, format string, opts ExportOptions) (string, error) {
+	data, err := renderExport(result, format, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", strings.ToUpper(format), err)
+	}
+
+	path := filepath.Join(dir, base+"."+format)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to save %s: %w", strings.ToUpper(format), err)
+	}
+	return path, nil
+}
+
+// handleExport serves a finished job's transcript as a file:
+// GET /jobs/{id}/export?format=vtt. format=zip bundles every format.
+func handleExport(w http.ResponseWriter, r *http.Request, jobID string) {
+	if r.Method != http.MethodGet {
+		sendJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = "txt"
+	}
+	if _, ok := exportFormats[format]; !ok && format != zipFormat {
+		sendJSONError(w, fmt.Sprintf("Unknown format %q (supported: %s, %s)", format, strings.Join(exportFormatNames, ", "), zipFormat), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := parseExportOptions(query)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job := engine.GetJob(jobID)
+	if job == nil {
+		sendJSONError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != StatusCompleted || job.Result == nil {
+		sendJSONError(w, fmt.Sprintf("Job is %s, not completed", job.Status), http.StatusConflict)
+		return
+	}
+	opts.JobID = job.ID
+	opts.Source = job.FileName
+
+	fileName := exportFileName(job.FileName, format)
+	var data []byte
+	contentType := "application/zip"
+	if format == zipFormat {
+		data, err = renderZIP(job.Result, strings.TrimSuffix(fileName, "."+zipFormat), opts)
+	} else {
+		data, err = renderExport(job.Result, format, opts)
+		contentType = exportFormats[format].ContentType
+	}
+	if err != nil {
+		sendJSONError(w, fmt.Sprintf("Failed to render %s: %v", strings.ToUpper(format), err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	w.Write(data)
+}
+
+// renderTXT writes the plain text, or one line per segment if timestamps
+// are requested or the segments have speakers
+func renderTXT(result *TranscriptionResult, opts ExportOptions) ([]byte, error) {
+	if !opts.Timestamps && !hasSpeakers(result.Segments) {
+		if opts.MaxLineLength <= 0 {
+			return []byte(result.Text), nil
+		}
+		return []byte(wrapText(strings.TrimSpace(result.Text), opts.MaxLineLength) + "\n"), nil
+	}
+
+	var txt strings.Builder
+	for _, segment := range result.Segments {
+		line := speakerPrefix(segment, "") + strings.TrimSpace(segment.Text)
+		if opts.Timestamps {
+			line = "[" + formatClock(segment.Start) + "] " + line
+		}
+		txt.WriteString(wrapText(line, opts.MaxLineLength))
+		txt.WriteString("\n")
+	}
+	return []byte(txt.String()), nil
+}
+
+func renderJSON(result *TranscriptionResult, _ ExportOptions) ([]byte, error) {
+	return json.MarshalIndent(result, "", "  ")
+}
+
+func renderSRT(result *TranscriptionResult, opts ExportOptions) ([]byte, error) {
+	return []byte(generateSRT(subtitleCues(result.Segments, opts))), nil
+}
+
+func renderVTT(result *TranscriptionResult, opts ExportOptions) ([]byte, error) {
+	return []byte(generateVTT(result, opts)), nil
+}
+
+// renderTSV writes start and end in milliseconds, like Whisper's own TSV
+// output. A speaker column is added when speakers have been identified.
+func renderTSV(result *TranscriptionResult, _ ExportOptions) ([]byte, error) {
+	speakers := hasSpeakers(result.Segments)
+	flatten := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+	var tsv strings.Builder
+	if speakers {
+		tsv.WriteString("start\tend\tspeaker\ttext\n")
+	} else {
+		tsv.WriteString("start\tend\ttext\n")
+	}
+	for _, segment := range result.Segments {
+		tsv.WriteString(fmt.Sprintf("%d\t%d\t", int64(math.Round(segment.Start*1000)), int64(math.Round(segment.End*1000))))
+		if speakers {
+			tsv.WriteString(flatten.Replace(segment.Speaker) + "\t")
+		}
+		tsv.WriteString(flatten.Replace(strings.TrimSpace(segment.Text)) + "\n")
+	}
+	return []byte(tsv.String()), nil
+}
+
+// renderCSV writes start and end in seconds. A speaker column is added when
+// speakers have been identified.
+func renderCSV(result *TranscriptionResult, _ ExportOptions) ([]byte, error) {
+	speakers := hasSpeakers(result.Segments)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	header := []string{"start", "end", "text"}
+	if speakers {
+		header = []string{"start", "end", "speaker", "text"}
+	}
+	writer.Write(header)
+	for _, segment := range result.Segments {
+		record := []string{strconv.FormatFloat(segment.Start, 'f', 3, 64), strconv.FormatFloat(segment.End, 'f', 3, 64)}
+		if speakers {
+			record = append(record, segment.Speaker)
+		}
+		writer.Write(append(record, strings.TrimSpace(segment.Text)))
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// renderMarkdown writes a titled document. With timestamps or speakers every
+// segment gets its own paragraph; otherwise the text is a single paragraph.
+func renderMarkdown(result *TranscriptionResult, opts ExportOptions) ([]byte, error) {
+	var md strings.Builder
+
+	title := "Transcript"
+	if opts.Source != "" {
+		title = opts.Source
+	}
+	md.WriteString("# " + strings.Join(strings.Fields(title), " ") + "\n\n")
+
+	if result.Language != "" {
+		md.WriteString("- **Language:** " + result.Language + "\n")
+	}
+	if result.Task == taskTranslate {
+		md.WriteString("- **Translated to:** en\n")
+	}
+	if result.Model != "" {
+		md.WriteString("- **Model:** " + result.Model + "\n")
+	}
+	if result.Duration > 0 {
+		md.WriteString("- **Duration:** " + formatClock(result.Duration) + "\n")
+	}
+	md.WriteString("\n")
+
+	if !opts.Timestamps && !hasSpeakers(result.Segments) {
+		md.WriteString(wrapText(strings.TrimSpace(result.Text), opts.MaxLineLength) + "\n")
+		return []byte(md.String()), nil
+	}
+
+	for _, segment := range result.Segments {
+		var line string
+		if opts.Timestamps {
+			line = "**[" + formatClock(segment.Start) + "]** "
+		}
+		line += speakerPrefix(segment, "**") + strings.TrimSpace(segment.Text)
+		md.WriteString(wrapText(line, opts.MaxLineLength) + "\n\n")
+	}
+	return []byte(md.String()), nil
+}
+
+// hasSpeakers reports whether any segment has a speaker label
+func hasSpeakers(segments []TranscriptionSegment) bool {
+	for _, segment := range segments {
+		if segment.Speaker != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// speakerPrefix returns "Speaker: " for a labelled segment, with the label
+// wrapped in emphasis, e.g. "**" for Markdown bold
+func speakerPrefix(segment TranscriptionSegment, emphasis string) string {
+	if segment.Speaker == "" {
+		return ""
+	}
+	return emphasis + segment.Speaker + ":" + emphasis + " "
+}
+
+// wrapText breaks text into lines of at most width characters at spaces.
+// Words longer than width get a line of their own. A width of 0 or less
+// leaves the text unchanged.
+func wrapText(text string, width int) string {
+	if width <= 0 {
+		return text
+	}
+
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			switch {
+			case line == "":
+				line = word
+			case len([]rune(line))+1+len([]rune(word)) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// generateSRT creates SRT subtitle format from cues
+func generateSRT(cues []subtitleCue) string {
+	var srt strings.Builder
+
+	for i, cue := range cues {
+		// Cue number
+		srt.WriteString(fmt.Sprintf("%d\n", i+1))
+
+		// Timestamps
+		startTime := formatSRTTime(cue.Start)
+		endTime := formatSRTTime(cue.End)
+		srt.WriteString(fmt.Sprintf("%s --> %s\n", startTime, endTime))
+
+		// Text, with the speaker in front if known
+		if cue.Speaker != "" {
+			srt.WriteString(cue.Speaker + ": " + strings.TrimSpace(cue.Text))
+		} else {
+			srt.WriteString(cue.Text)
+		}
+		srt.WriteString("\n\n")
+	}
+
+	return srt.String()
+}
+
+// VTTOptions controls the WebVTT output. The cue settings apply to every cue
+// and are left to the player when empty.
+type VTTOptions struct {
+	Position string // Horizontal position, e.g. "50%" or "10%,line-left"
+	Line     string // Vertical position, a line number or percentage, e.g. "-2" or "85%,end"
+	Align    string // start, center, end, left or right
+	OmitNote bool   // Leave out the NOTE block describing the transcription
+	Karaoke  bool   // Tag every word with its start time, for segments that have word timings
+}
+
+var (
+	vttPositionPattern = regexp.MustCompile(`^(100|[1-9]?[0-9])(\.[0-9]+)?%(,(line-left|center|line-right))?$`)
+	vttLinePattern     = regexp.MustCompile(`^(-?[0-9]+|(100|[1-9]?[0-9])(\.[0-9]+)?%)(,(start|center|end))?$`)
+	vttAligns          = map[string]bool{"start": true, "center": true, "end": true, "left": true, "right": true}
+)
+
+// parseVTTOptions reads the position, line, align and note query parameters.
+// note=0 leaves out the NOTE block.
+func parseVTTOptions(query url.Values) (VTTOptions, error) {
+	opts := VTTOptions{
+		Position: query.Get("position"),
+		Line:     query.Get("line"),
+		Align:    query.Get("align"),
+		OmitNote: query.Get("note") == "0" || query.Get("note") == "false",
+	}
+	if value := query.Get("karaoke"); value != "" {
+		karaoke, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid karaoke %q (true or false)", value)
+		}
+		opts.Karaoke = karaoke
+	}
+	if opts.Position != "" && !vttPositionPattern.MatchString(opts.Position) {
+		return opts, fmt.Errorf("invalid cue position %q (e.g. 50%% or 10%%,line-left)", opts.Position)
+	}
+	if opts.Line != "" && !vttLinePattern.MatchString(opts.Line) {
+		return opts, fmt.Errorf("invalid cue line %q (e.g. -2 or 85%%,end)", opts.Line)
+	}
+	if opts.Align != "" && !vttAligns[opts.Align] {
+		return opts, fmt.Errorf("invalid cue align %q (start, center, end, left or right)", opts.Align)
+	}
+	return opts, nil
+}
+
+// cueSettings returns the settings appended to every cue timing line
+func (o VTTOptions) cueSettings() string {
+	var settings []string
+	if o.Position != "" {
+		settings = append(settings, "position:"+o.Position)
+	}
+	if o.Line != "" {
+		settings = append(settings, "line:"+o.Line)
+	}
+	if o.Align != "" {
+		settings = append(settings, "align:"+o.Align)
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	return " " + strings.Join(settings, " ")
+}
+
+// vttEscaper escapes the characters that are markup in cue text
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// generateVTT creates WebVTT subtitle format from a result. Cues with a
+// speaker get a <v Speaker> voice tag.
+func generateVTT(result *TranscriptionResult, opts ExportOptions) string {
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n\n")
+
+	if !opts.VTT.OmitNote {
+		vtt.WriteString(vttNote(result, opts))
+	}
+
+	settings := opts.VTT.cueSettings()
+	for _, cue := range subtitleCues(result.Segments, opts) {
+		vtt.WriteString(fmt.Sprintf("%s --> %s%s\n", formatVTTTime(cue.Start), formatVTTTime(cue.End), settings))
+		if cue.Speaker != "" {
+			// Annotations end at ">"; escape it so a speaker name can't close the tag early
+			vtt.WriteString("<v " + strings.ReplaceAll(vttEscaper.Replace(cue.Speaker), "\n", " ") + ">")
+		}
+		if opts.VTT.Karaoke && len(cue.Words) > 0 {
+			vtt.WriteString(karaokeText(cue))
+		} else {
+			vtt.WriteString(vttEscaper.Replace(strings.TrimSpace(cue.Text)))
+		}
+		vtt.WriteString("\n\n")
+	}
+
+	return vtt.String()
+}
+
+// karaokeText writes a cue's words with a timestamp tag before each word
+// after the first, so players can highlight the words as they are spoken.
+// Cue timestamps must lie within the cue, so word times are clamped to it.
+// The text comes from the words themselves, broken into lines where the
+// cue's text is, so no word is lost if they don't split the same way.
+func karaokeText(cue subtitleCue) string {
+	var lineLengths []int
+	for _, line := range strings.Split(strings.TrimSpace(cue.Text), "\n") {
+		lineLengths = append(lineLengths, utf8.RuneCountInString(strings.TrimSpace(line)))
+	}
+
+	var text strings.Builder
+	line, used := 0, 0
+	for i, word := range cue.Words {
+		length := utf8.RuneCountInString(word.Text)
+		if i > 0 {
+			if used+1+length > lineLengths[line] && line+1 < len(lineLengths) {
+				text.WriteString("\n")
+				line, used = line+1, 0
+			} else {
+				text.WriteString(" ")
+				used++
+			}
+			start := math.Min(math.Max(word.Start, cue.Start), cue.End)
+			text.WriteString("<" + formatVTTTime(start) + ">")
+		}
+		text.WriteString(vttEscaper.Replace(word.Text))
+		used += length
+	}
+	return text.String()
+}
+
+// vttNote returns a NOTE block describing the transcription. A NOTE can't
+// contain "-->" or a blank line, so values are flattened.
+func vttNote(result *TranscriptionResult, opts ExportOptions) string {
+	clean := func(value string) string {
+		value = strings.Join(strings.Fields(value), " ")
+		return strings.ReplaceAll(value, "-->", "->")
+	}
+
+	var note strings.Builder
+	note.WriteString("NOTE\n")
+	note.WriteString("Transcribed by Transcriber Pro " + clean(Version) + "\n")
+	if opts.Source != "" {
+		note.WriteString("Source: " + clean(opts.Source) + "\n")
+	}
+	if opts.JobID != "" {
+		note.WriteString("Job: " + clean(opts.JobID) + "\n")
+	}
+	if result.Language != "" {
+		note.WriteString("Language: " + clean(result.Language) + "\n")
+	}
+	if result.Task == taskTranslate {
+		note.WriteString("Translated to: en\n")
+	}
+	if result.Model != "" {
+		note.WriteString("Model: " + clean(result.Model) + "\n")
+	}
+	if result.Duration > 0 {
+		note.WriteString("Duration: " + formatVTTTime(result.Duration) + "\n")
+	}
+	note.WriteString("\n")
+	return note.String()
+}
+
+// formatSRTTime formats seconds to SRT timestamp format (HH:MM:SS,mmm)
+func formatSRTTime(seconds float64) string {
+	hours := int(seconds / 3600)
+	minutes := int((seconds - float64(hours*3600)) / 60)
+	secs := int(seconds - float64(hours*3600) - float64(minutes*60))
+	millis := int((seconds - float64(int(seconds))) * 1000)
+
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", hours, minutes, secs, millis)
+}
+
+// formatClock formats seconds as HH:MM:SS for plain-text timestamps
+func formatClock(seconds float64) string {
+	total := int(seconds)
+	return fmt.Sprintf("%02d:%02d:%02d", total/3600, total/60%60, total%60)
+}
+
+// formatVTTTime formats seconds to WebVTT timestamp format (HH:MM:SS.mmm)
+func formatVTTTime(seconds float64) string {
+	return strings.Replace(formatSRTTime(seconds), ",", ".", 1)
+}
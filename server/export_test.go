@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKaraokeTextKeepsLineBreaks(t *testing.T) {
+	cue := subtitleCue{
+		Start: 1, End: 4,
+		Text: "We went down\nto the river",
+		Words: []TranscriptionWord{
+			{Text: "We", Start: 1, End: 1.25},
+			{Text: "went", Start: 1.25, End: 1.5},
+			{Text: "down", Start: 1.5, End: 2},
+			{Text: "to", Start: 2.125, End: 2.25},
+			{Text: "the", Start: 2.25, End: 2.5},
+			{Text: "river", Start: 2.5, End: 3},
+		},
+	}
+
+	want := "We <00:00:01.250>went <00:00:01.500>down\n<00:00:02.125>to <00:00:02.250>the <00:00:02.500>river"
+	if got := karaokeText(cue); got != want {
+		t.Errorf("karaokeText =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestKaraokeTextKeepsEveryWord(t *testing.T) {
+	// The segment text doesn't split into the same fields as the words
+	cue := subtitleCue{
+		Start: 0, End: 3,
+		Text: "Well-known … places",
+		Words: []TranscriptionWord{
+			{Text: "Well", Start: 0, End: 0.4},
+			{Text: "-known", Start: 0.4, End: 0.9},
+			{Text: "…", Start: 1, End: 1.2},
+			{Text: "places", Start: 1.5, End: 2},
+			{Text: "again", Start: 2, End: 2.5},
+		},
+	}
+
+	got := karaokeText(cue)
+	for _, word := range cue.Words {
+		if !strings.Contains(got, word.Text) {
+			t.Errorf("karaokeText dropped %q: %q", word.Text, got)
+		}
+	}
+	if tags := strings.Count(got, "<00:"); tags != len(cue.Words)-1 {
+		t.Errorf("got %d timestamp tags, want %d: %q", tags, len(cue.Words)-1, got)
+	}
+}
+
+func TestKaraokeTextClampsToCue(t *testing.T) {
+	cue := subtitleCue{
+		Start: 2, End: 3,
+		Text: "early late",
+		Words: []TranscriptionWord{
+			{Text: "early", Start: 1.5, End: 2.2},
+			{Text: "late", Start: 3.5, End: 4},
+		},
+	}
+	if got, want := karaokeText(cue), "early <00:00:03.000>late"; got != want {
+		t.Errorf("karaokeText = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImportOptions controls a model import. Name and SHA256 only apply when a
+// single model file is imported.
+type ImportOptions struct {
+	Name            string // Register the model under this name instead of the file name
+	SHA256          string // Expected checksum of the model file
+	AllowUnverified bool   // Accept models no checksum is known for
+}
+
+// ImportedModel describes a model added by an import
+type ImportedModel struct {
+	Name     string `json:"name"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	Verified bool   `json:"verified"` // False only for models accepted with AllowUnverified
+}
+
+// stagedModel is a model file copied into the model directory under a
+// temporary name, waiting for its checksum to be verified
+type stagedModel struct {
+	name    string
+	tmpPath string
+	sha256  string
+	size    int64
+}
+
+// Import copies models from src into the model directory without touching
+// the network. src may be a single ggml model file, a directory, or a .tar,
+// .tar.gz/.tgz or .zip archive; directories and archives import every
+// ggml-<name>.bin they contain. Each file must have a valid ggml header and a
+// matching SHA-256. Checksums come from opts.SHA256, a manifest.json or
+// <file>.sha256 next to the model, the catalog, or the model directory's own
+// manifest, in that order; models with none are rejected unless
+// opts.AllowUnverified is set.
+func (m *ModelManager) Import(src string, opts ImportOptions) ([]ImportedModel, error) {
+	stat, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create model directory: %w", err)
+	}
+
+	lower := strings.ToLower(src)
+	switch {
+	case stat.IsDir():
+		return m.importDir(src, opts)
+	case strings.HasSuffix(lower, ".tar"), strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return m.importTar(src, opts)
+	case strings.HasSuffix(lower, ".zip"):
+		return m.importZip(src, opts)
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = modelNameFromFile(src)
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	staged, err := m.stage(name, file)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := opts.SHA256
+	if expected == "" {
+		expected = readSidecarSHA(src)
+	}
+	if expected == "" {
+		if manifest, err := readManifestFile(filepath.Join(filepath.Dir(src), "manifest.json")); err == nil {
+			expected = manifest[name].SHA256
+		}
+	}
+
+	imported, err := m.commit(staged, expected, opts.AllowUnverified)
+	if err != nil {
+		return nil, err
+	}
+	return []ImportedModel{imported}, nil
+}
+
+// ImportReader imports a single model streamed from r, e.g. an HTTP upload
+func (m *ModelManager) ImportReader(r io.Reader, opts ImportOptions) (ImportedModel, error) {
+	if opts.Name == "" {
+		return ImportedModel{}, errors.New("model name required")
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return ImportedModel{}, fmt.Errorf("failed to create model directory: %w", err)
+	}
+
+	staged, err := m.stage(opts.Name, r)
+	if err != nil {
+		return ImportedModel{}, err
+	}
+	return m.commit(staged, opts.SHA256, opts.AllowUnverified)
+}
+
+func (m *ModelManager) importDir(dir string, opts ImportOptions) ([]ImportedModel, error) {
+	if opts.Name != "" || opts.SHA256 != "" {
+		return nil, errors.New("--name and --sha256 only apply to a single model file")
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "ggml-*.bin"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no ggml-*.bin models found in %s", dir)
+	}
+
+	var imported []ImportedModel
+	for _, match := range matches {
+		models, err := m.Import(match, ImportOptions{AllowUnverified: opts.AllowUnverified})
+		if err != nil {
+			return imported, fmt.Errorf("%s: %w", filepath.Base(match), err)
+		}
+		imported = append(imported, models...)
+	}
+	return imported, nil
+}
+
+func (m *ModelManager) importTar(path string, opts ImportOptions) ([]ImportedModel, error) {
+	if opts.Name != "" || opts.SHA256 != "" {
+		return nil, errors.New("--name and --sha256 only apply to a single model file")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if lower := strings.ToLower(path); strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	// A tar can only be read front to back and its manifest may come after
+	// the models, so every model is staged before any is verified
+	var staged []stagedModel
+	manifest := make(map[string]manifestEntry)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			discardStaged(staged)
+			return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		base := filepath.Base(header.Name)
+		switch {
+		case base == "manifest.json":
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				discardStaged(staged)
+				return nil, fmt.Errorf("failed to parse manifest.json in %s: %w", filepath.Base(path), err)
+			}
+		case isModelFileName(base):
+			model, err := m.stage(modelNameFromFile(base), tr)
+			if err != nil {
+				discardStaged(staged)
+				return nil, fmt.Errorf("%s: %w", base, err)
+			}
+			staged = append(staged, model)
+		}
+	}
+
+	return m.commitAll(staged, manifest, path, opts.AllowUnverified)
+}
+
+func (m *ModelManager) importZip(path string, opts ImportOptions) ([]ImportedModel, error) {
+	if opts.Name != "" || opts.SHA256 != "" {
+		return nil, errors.New("--name and --sha256 only apply to a single model file")
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+	defer zr.Close()
+
+	var staged []stagedModel
+	manifest := make(map[string]manifestEntry)
+	for _, entry := range zr.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		base := filepath.Base(entry.Name)
+		if base != "manifest.json" && !isModelFileName(base) {
+			continue
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			discardStaged(staged)
+			return nil, fmt.Errorf("%s: %w", base, err)
+		}
+		if base == "manifest.json" {
+			err = json.NewDecoder(rc).Decode(&manifest)
+		} else {
+			var model stagedModel
+			model, err = m.stage(modelNameFromFile(base), rc)
+			if err == nil {
+				staged = append(staged, model)
+			}
+		}
+		rc.Close()
+		if err != nil {
+			discardStaged(staged)
+			return nil, fmt.Errorf("%s: %w", base, err)
+		}
+	}
+
+	return m.commitAll(staged, manifest, path, opts.AllowUnverified)
+}
+
+// commitAll verifies and installs the models staged from an archive
+func (m *ModelManager) commitAll(staged []stagedModel, manifest map[string]manifestEntry, path string, allowUnverified bool) ([]ImportedModel, error) {
+	if len(staged) == 0 {
+		return nil, fmt.Errorf("no ggml-*.bin models found in %s", filepath.Base(path))
+	}
+
+	var imported []ImportedModel
+	for i, model := range staged {
+		result, err := m.commit(model, manifest[model.name].SHA256, allowUnverified)
+		if err != nil {
+			discardStaged(staged[i+1:])
+			return imported, fmt.Errorf("%s: %w", model.name, err)
+		}
+		imported = append(imported, result)
+	}
+	return imported, nil
+}
+
+// stage copies a model into the model directory under a temporary name,
+// hashing it on the way, and checks its ggml header
+func (m *ModelManager) stage(name string, r io.Reader) (stagedModel, error) {
+	if !validModelName(name) {
+		return stagedModel{}, fmt.Errorf("invalid model name %q", name)
+	}
+
+	tmp, err := os.CreateTemp(m.dir, "ggml-"+name+".bin.import-*")
+	if err != nil {
+		return stagedModel{}, fmt.Errorf("failed to create model file: %w", err)
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return stagedModel{}, fmt.Errorf("failed to copy model: %w", err)
+	}
+
+	if err := checkStagedHeader(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return stagedModel{}, err
+	}
+
+	return stagedModel{
+		name:    name,
+		tmpPath: tmp.Name(),
+		sha256:  hex.EncodeToString(h.Sum(nil)),
+		size:    size,
+	}, nil
+}
+
+// commit checks a staged model and moves it into place and records it.
+// A model the catalog or the model directory's manifest knows a checksum for
+// must match that checksum, and expected may only confirm it; for other
+// names, expected is the checksum to match. Without any checksum the model
+// is rejected unless allowUnverified is set.
+func (m *ModelManager) commit(model stagedModel, expected string, allowUnverified bool) (ImportedModel, error) {
+	expected = strings.ToLower(strings.TrimSpace(expected))
+
+	known := ""
+	if info, ok := catalogModel(model.name); ok {
+		known = info.SHA256
+	}
+	if known == "" {
+		if entry, ok := m.manifestEntry(model.name); ok {
+			known = entry.SHA256
+		}
+	}
+	if known = strings.ToLower(known); known != "" {
+		if expected != "" && expected != known {
+			os.Remove(model.tmpPath)
+			return ImportedModel{}, fmt.Errorf("checksum %s doesn't match the known checksum of model %s (%s)", expected, model.name, known)
+		}
+		expected = known
+	}
+
+	verified := false
+	if expected != "" {
+		if model.sha256 != expected {
+			os.Remove(model.tmpPath)
+			return ImportedModel{}, fmt.Errorf("checksum mismatch: got %s, expected %s", model.sha256, expected)
+		}
+		verified = true
+	} else if allowUnverified {
+		log.Printf("[Import %s] No known checksum, accepting file with SHA-256 %s as requested", model.name, model.sha256)
+	} else {
+		os.Remove(model.tmpPath)
+		return ImportedModel{}, fmt.Errorf("%w for model %s (SHA-256 %s); give its expected SHA-256, or allow unverified models to accept it anyway", ErrNoChecksum, model.name, model.sha256)
+	}
+
+	if err := os.Rename(model.tmpPath, m.Path(model.name)); err != nil {
+		os.Remove(model.tmpPath)
+		return ImportedModel{}, fmt.Errorf("failed to move model into place: %w", err)
+	}
+	if err := m.recordManifest(model.name, manifestEntry{SHA256: model.sha256, Size: model.size}); err != nil {
+		log.Printf("Warning: failed to update model manifest: %v", err)
+	}
+
+	log.Printf("[Import %s] Installed %s (%d MB, verified: %v)", model.name, m.Path(model.name), model.size/mb, verified)
+	return ImportedModel{Name: model.name, SHA256: model.sha256, Size: model.size, Verified: verified}, nil
+}
+
+// checkStagedHeader checks the ggml header of a staged file, naming the
+// model rather than the temporary file in errors
+func checkStagedHeader(path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return checkModelHeader(file, "ggml-"+name+".bin")
+}
+
+func discardStaged(staged []stagedModel) {
+	for _, model := range staged {
+		os.Remove(model.tmpPath)
+	}
+}
+
+// isModelFileName reports whether base is named like a ggml-<name>.bin model
+func isModelFileName(base string) bool {
+	return strings.HasPrefix(base, "ggml-") && strings.HasSuffix(base, ".bin") && len(base) > len("ggml-.bin")
+}
+
+// modelNameFromFile derives a model name from a file name, so
+// ggml-large-v3.bin registers as large-v3
+func modelNameFromFile(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(strings.TrimPrefix(base, "ggml-"), filepath.Ext(base))
+}
+
+// readSidecarSHA returns the checksum in <path>.sha256, which may be in
+// sha256sum output format
+func readSidecarSHA(path string) string {
+	data, err := os.ReadFile(path + ".sha256")
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
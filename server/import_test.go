@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testModelManager returns a model manager with an empty model directory
+func testModelManager(t *testing.T) *ModelManager {
+	t.Helper()
+	return NewModelManager(&Config{ModelDir: t.TempDir(), Offline: true})
+}
+
+// writeModelFile writes a fake ggml model to a temporary file and returns its
+// path and SHA-256
+func writeModelFile(t *testing.T, name string) (string, string) {
+	t.Helper()
+	data, sum := testModel(t, 16*1024)
+	path := filepath.Join(t.TempDir(), "ggml-"+name+".bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, sum
+}
+
+func TestImportRejectsChecksumOverridingCatalog(t *testing.T) {
+	m := testModelManager(t)
+	path, sum := writeModelFile(t, "tiny")
+
+	_, err := m.Import(path, ImportOptions{Name: "tiny", SHA256: sum})
+	if err == nil || !strings.Contains(err.Error(), "known checksum") {
+		t.Fatalf("err = %v, want the catalog checksum to win", err)
+	}
+	if _, err := os.Stat(m.Path("tiny")); !os.IsNotExist(err) {
+		t.Error("model installed under a catalog name with a checksum of the caller's choosing")
+	}
+	if _, ok := m.manifestEntry("tiny"); ok {
+		t.Error("caller's checksum recorded in the manifest")
+	}
+}
+
+func TestImportRejectsChecksumOverridingManifest(t *testing.T) {
+	m := testModelManager(t)
+	path, sum := writeModelFile(t, "custom")
+	if _, err := m.Import(path, ImportOptions{SHA256: sum}); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	// A different file, with its own checksum, under the same name
+	other := filepath.Join(t.TempDir(), "ggml-custom.bin")
+	data, otherSum := testModel(t, 8*1024)
+	os.WriteFile(other, data, 0644)
+
+	if _, err := m.Import(other, ImportOptions{SHA256: otherSum}); err == nil {
+		t.Fatal("Import replaced a model whose checksum is in the manifest")
+	}
+	if entry, _ := m.manifestEntry("custom"); entry.SHA256 != sum {
+		t.Errorf("manifest checksum = %s, want %s", entry.SHA256, sum)
+	}
+}
+
+func TestImportAcceptsCallerChecksumForUnknownModel(t *testing.T) {
+	m := testModelManager(t)
+	path, sum := writeModelFile(t, "custom")
+
+	imported, err := m.Import(path, ImportOptions{SHA256: strings.ToUpper(sum)})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if len(imported) != 1 || !imported[0].Verified {
+		t.Errorf("imported = %+v, want one verified model", imported)
+	}
+}
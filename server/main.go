@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -12,45 +14,84 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-const (
-	uploadDir     = "/tmp/transcriber-uploads"
-	maxUploadSize = 20 * 1024 * 1024 * 1024 // 20GB limit
-)
-
-func getPort() string {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8456" // Default port
-	}
-	return port
-}
-
 var Version = "dev"
 
 var engine *TranscriptionEngine
 
+var watcher *FolderWatcher // nil unless watch folders are configured
+
+var webhooks *WebhookNotifier
+
+var broker *EventBroker
+
+var vocabularies *VocabularyStore
+
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "--version" {
-		fmt.Println(Version)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "--version":
+			fmt.Println(Version)
+			return
+		case "models":
+			os.Exit(runModelsCommand(os.Args[2:]))
+		case "transcribe":
+			os.Exit(runTranscribeCommand(os.Args[2:]))
+		case "queue", "submit", "watch", "kill", "cancel", "clear", "export", "vocab":
+			os.Exit(runClientCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
+	cfg, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		return
+	} else if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	var err error
-	engine, err = NewTranscriptionEngine()
+	// The notifier is closed after the engine, so the jobs failed on shutdown
+	// are still delivered or recorded as abandoned
+	webhooks = NewWebhookNotifier(cfg)
+	defer webhooks.Close()
+
+	engine, err = NewTranscriptionEngine(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize transcription engine: %v", err)
 	}
 	defer engine.Close()
 
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+	vocabularies, err = OpenVocabularyStore(filepath.Join(cfg.StateDir, "vocabularies.json"))
+	if err != nil {
+		log.Fatalf("Failed to load vocabularies: %v", err)
+	}
+
+	// Listeners go first, so restored jobs don't start unheard
+	engine.OnJobEvent(webhooks.Notify)
+	broker = NewEventBroker()
+	engine.OnJobEvent(broker.Publish)
+	engine.Start()
+
+	if err := os.MkdirAll(cfg.UploadDir, 0755); err != nil {
 		log.Fatalf("Failed to create upload directory: %v", err)
 	}
+	engine.CleanupUploads(cfg.UploadDir)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	if len(cfg.WatchFolders) > 0 {
+		watcher, err = NewFolderWatcher(engine, cfg.WatchFolders, filepath.Join(cfg.StateDir, "watch-ledger.jsonl"))
+		if err != nil {
+			log.Fatalf("Failed to set up watch folders: %v", err)
+		}
+		go watcher.Run(ctx)
+	}
 
 	// Try to find static directory in multiple locations
 	staticDir := findStaticDir()
@@ -76,17 +117,31 @@ func main() {
 	http.HandleFunc("/version", handleVersion)
 	http.HandleFunc("/transcribe", handleTranscribe)
 	http.HandleFunc("/progress/", handleProgress)
+	http.HandleFunc("/jobs/", handleJobs)
 	http.HandleFunc("/queue", handleQueue)
+	http.HandleFunc("/events", handleEvents)
+	http.HandleFunc("/ws", handleWebSocket)
 	http.HandleFunc("/clear-completed", handleClearCompleted)
 	http.HandleFunc("/clear-all", handleClearAll)
 	http.HandleFunc("/cancel-job/", handleCancelJob)
 	http.HandleFunc("/kill-job/", handleKillJob)
-
-	port := getPort()
-	serverURL := fmt.Sprintf("http://localhost:%s", port)
-
-	// Skip browser opening if NO_BROWSER env var is set (useful for testing)
-	if os.Getenv("NO_BROWSER") == "" {
+	http.HandleFunc("/kill-audit", handleKillAudit)
+	http.HandleFunc("/models", handleModels)
+	http.HandleFunc("/models/download", handleModelDownload)
+	http.HandleFunc("/models/downloads", handleModelDownloads)
+	http.HandleFunc("/models/import", handleModelImport)
+	http.HandleFunc("/config", handleConfig)
+	http.HandleFunc("/watch", handleWatch)
+	http.HandleFunc("/webhooks/deliveries", handleWebhookDeliveries)
+	http.HandleFunc("/vocabularies", handleVocabularies)
+	http.HandleFunc("/vocabularies/", handleVocabularies)
+	http.HandleFunc("/v1/audio/transcriptions", handleOpenAITranscriptions)
+	http.HandleFunc("/v1/audio/translations", handleOpenAITranslations)
+
+	serverURL := cfg.ServerURL()
+
+	// Skip browser opening with --no-browser or NO_BROWSER (useful for testing)
+	if cfg.OpenBrowser {
 		go func() {
 			time.Sleep(1500 * time.Millisecond)
 			openBrowser(serverURL)
@@ -99,15 +154,24 @@ func main() {
 	fmt.Println()
 	fmt.Printf("Server running at %s\n", serverURL)
 	fmt.Println()
-	fmt.Println("The companion will automatically download the Whisper model on first run (~3GB).")
-	fmt.Println("This may take several minutes depending on your internet connection.")
+	fmt.Printf("Default model: %s\n", engine.Models().Default())
+	if engine.Models().Offline() {
+		fmt.Println("Offline mode: models are never downloaded. Add them with `transcriber-pro models import`.")
+	} else {
+		fmt.Println("The companion will automatically download the default Whisper model on first run.")
+		fmt.Println("This may take several minutes depending on your internet connection.")
+	}
+	fmt.Println()
+	fmt.Println("Configuration:")
+	cfg.Print(os.Stdout)
 	fmt.Println()
 	fmt.Println("Press Ctrl+C to stop the server.")
 	fmt.Println()
 
 	srv := &http.Server{
-		Addr: ":" + port,
+		Addr: cfg.Listen,
 	}
+	srv.RegisterOnShutdown(broker.Close)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
@@ -115,6 +179,7 @@ func main() {
 	go func() {
 		<-sigChan
 		fmt.Println("\nShutting down...")
+		stop()
 		srv.Shutdown(context.Background())
 	}()
 
@@ -152,7 +217,8 @@ func handleTranscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	cfg := engine.Config()
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxUploadSize())
 
 	// Parse multipart form with 32MB memory limit, rest goes to disk
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
@@ -171,13 +237,92 @@ func handleTranscribe(w http.ResponseWriter, r *http.Request) {
 
 	language := r.FormValue("language")
 	if language == "" {
-		language = "auto"
+		language = cfg.DefaultLanguage
+	}
+
+	model, err := engine.Models().Resolve(r.FormValue("model"))
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	callbackURL := r.FormValue("callback_url")
+	if callbackURL != "" {
+		if err := validateWebhookURL(callbackURL); err != nil {
+			sendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var wordTimestamps bool
+	if value := r.FormValue("word_timestamps"); value != "" {
+		if wordTimestamps, err = strconv.ParseBool(value); err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid word_timestamps %q (true or false)", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var splitChannels bool
+	if value := r.FormValue("split_channels"); value != "" {
+		if splitChannels, err = strconv.ParseBool(value); err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid split_channels %q (true or false)", value), http.StatusBadRequest)
+			return
+		}
+	}
+	speakers := parseSpeakers(r.FormValue("speakers"))
+
+	var diarize bool
+	if value := r.FormValue("diarize"); value != "" {
+		if diarize, err = strconv.ParseBool(value); err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid diarize %q (true or false)", value), http.StatusBadRequest)
+			return
+		}
+	}
+	if diarize && cfg.DiarizeCommand == "" {
+		sendJSONError(w, "Diarization is not available: the server has no diarize_command configured", http.StatusBadRequest)
+		return
+	}
+
+	var speakerTurns []SpeakerTurn
+	if rttm, _, err := r.FormFile("rttm"); err == nil {
+		speakerTurns, err = parseRTTM(rttm)
+		rttm.Close()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid RTTM file: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if diarize && speakerTurns != nil {
+		sendJSONError(w, "Use either diarize or an RTTM file, not both", http.StatusBadRequest)
+		return
+	}
+	if (diarize || speakerTurns != nil) && (splitChannels || len(speakers) > 0) {
+		sendJSONError(w, "Diarization can't be combined with split_channels", http.StatusBadRequest)
+		return
 	}
 
+	task, err := parseTask(r.FormValue("task"))
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The prompt is resolved now, so editing the vocabulary later doesn't
+	// change what queued jobs run with
+	var vocabulary *Vocabulary
+	if name := r.FormValue("vocabulary"); name != "" {
+		if vocabulary, err = vocabularies.Get(name); err != nil {
+			sendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	prompt, termsDropped := buildPrompt(r.FormValue("initial_prompt"), vocabulary)
+
 	jobID := uuid.New().String()
 	fileName := header.Filename
 	ext := filepath.Ext(fileName)
-	audioPath := filepath.Join(uploadDir, jobID+ext)
+	audioPath := filepath.Join(cfg.UploadDir, jobID+ext)
 
 	dst, err := os.Create(audioPath)
 	if err != nil {
@@ -193,14 +338,50 @@ func handleTranscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	opts := JobOptions{
+		Language:       language,
+		Model:          model,
+		CallbackURL:    callbackURL,
+		Prompt:         prompt,
+		Vocabulary:     r.FormValue("vocabulary"),
+		TermsDropped:   termsDropped,
+		WordTimestamps: wordTimestamps,
+		SplitChannels:  splitChannels || len(speakers) > 0,
+		Speakers:       speakers,
+		Diarize:        diarize,
+		SpeakerTurns:   speakerTurns,
+		Translate:      task == taskTranslate,
+	}
+	response := map[string]string{
+		"job_id": jobID,
+		"status": string(StatusQueued),
+	}
+
+	var translationID, translationPath string
+	if task == taskBoth {
+		// The engine deletes each job's audio once it is done with it, so
+		// the translation gets its own link to the upload
+		translationID = uuid.New().String()
+		translationPath = filepath.Join(cfg.UploadDir, translationID+ext)
+		if err := linkOrCopy(audioPath, translationPath); err != nil {
+			os.Remove(audioPath)
+			sendJSONError(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
+		opts.LinkedJobID = translationID
+		response["translation_job_id"] = translationID
+	}
+
 	// Create job and add to queue - queue processor will handle transcription
-	engine.CreateJob(jobID, fileName, audioPath, language)
+	engine.CreateJob(jobID, fileName, audioPath, opts)
+	if translationID != "" {
+		opts.Translate = true
+		opts.LinkedJobID = jobID
+		engine.CreateJob(translationID, fileName, translationPath, opts)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"job_id": jobID,
-		"status": string(StatusQueued),
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
 func handleProgress(w http.ResponseWriter, r *http.Request) {
@@ -229,6 +410,22 @@ func handleProgress(w http.ResponseWriter, r *http.Request) {
 		response["result"] = job.Result
 	}
 
+	// Segments transcribed so far; ?since=N skips the first N segments
+	if job.Partial != nil {
+		segments := job.Partial.Segments
+		if since, err := strconv.Atoi(r.URL.Query().Get("since")); err == nil && since > 0 {
+			if since > len(segments) {
+				since = len(segments)
+			}
+			segments = segments[since:]
+		}
+		response["partial"] = map[string]interface{}{
+			"segments":      segments,
+			"segment_count": len(job.Partial.Segments),
+			"language":      job.Partial.Language,
+		}
+	}
+
 	if job.Status == StatusFailed {
 		response["error"] = job.Error
 	}
@@ -239,10 +436,10 @@ func handleProgress(w http.ResponseWriter, r *http.Request) {
 func findStaticDir() string {
 	// List of possible static directory locations
 	candidates := []string{
-		"./static",                                      // Current directory (dev mode, Windows)
-		"static",                                        // Relative path
-		"/opt/homebrew/share/transcriber-pro/static",   // Homebrew (Apple Silicon)
-		"/usr/local/share/transcriber-pro/static",      // Homebrew (Intel)
+		"./static", // Current directory (dev mode, Windows)
+		"static",   // Relative path
+		"/opt/homebrew/share/transcriber-pro/static",      // Homebrew (Apple Silicon)
+		"/usr/local/share/transcriber-pro/static",         // Homebrew (Intel)
 		filepath.Join(filepath.Dir(os.Args[0]), "static"), // Next to binary
 	}
 
@@ -274,6 +471,46 @@ func openBrowser(url string) {
 	}
 }
 
+// parseSpeakers splits a comma-separated list of speaker names, one per
+// channel. Empty entries keep the default "Channel N" name.
+func parseSpeakers(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+	speakers := strings.Split(list, ",")
+	for i, speaker := range speakers {
+		speakers[i] = strings.TrimSpace(speaker)
+	}
+	return speakers
+}
+
+// parseTask checks a task= upload parameter, which defaults to transcribe
+func parseTask(task string) (string, error) {
+	switch task {
+	case "":
+		return taskTranscribe, nil
+	case taskTranscribe, taskTranslate, taskBoth:
+		return task, nil
+	}
+	return "", fmt.Errorf("invalid task %q (%s, %s or %s)", task, taskTranscribe, taskTranslate, taskBoth)
+}
+
+// handleJobs routes /jobs/{jobID}/{action}
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	jobID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	if jobID == "" {
+		sendJSONError(w, "Job ID required", http.StatusBadRequest)
+		return
+	}
+
+	switch action {
+	case "export":
+		handleExport(w, r, jobID)
+	default:
+		sendJSONError(w, "Not found", http.StatusNotFound)
+	}
+}
+
 func handleQueue(w http.ResponseWriter, r *http.Request) {
 	queuedJobs, completedJobs := engine.GetQueue()
 
@@ -359,7 +596,13 @@ func handleKillJob(w http.ResponseWriter, r *http.Request) {
 	// Kill the worker process (not the server!)
 	if err := engine.KillJob(jobID); err != nil {
 		log.Printf("[Server] Failed to kill job %s: %v", jobID, err)
-		sendJSONError(w, fmt.Sprintf("Failed to kill job: %v", err), http.StatusInternalServerError)
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, ErrJobNotFound) {
+			statusCode = http.StatusNotFound
+		} else if errors.Is(err, ErrJobNotRunning) {
+			statusCode = http.StatusConflict
+		}
+		sendJSONError(w, fmt.Sprintf("Failed to kill job: %v", err), statusCode)
 		return
 	}
 
@@ -371,3 +614,127 @@ func handleKillJob(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[Server] Job %s killed successfully, queue will continue", jobID)
 }
+
+func handleKillAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kills": engine.KillAudit(),
+	})
+}
+
+func handleModels(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"default": engine.Models().Default(),
+		"offline": engine.Models().Offline(),
+		"models":  engine.Models().List(),
+	})
+}
+
+func handleModelDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.FormValue("model")
+	if name == "" {
+		sendJSONError(w, "Model name required", http.StatusBadRequest)
+		return
+	}
+
+	if err := engine.Models().StartDownload(name); err != nil {
+		statusCode := http.StatusBadRequest
+		if errors.Is(err, ErrDownloadInProgress) {
+			statusCode = http.StatusConflict
+		} else if errors.Is(err, ErrOffline) {
+			statusCode = http.StatusServiceUnavailable
+		}
+		sendJSONError(w, err.Error(), statusCode)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "downloading",
+		"model":  name,
+	})
+}
+
+func handleModelDownloads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"downloads": engine.Models().Downloads(),
+	})
+}
+
+// handleModelImport installs a model without downloading it. The request
+// body is the model file and ?name= is required. Files already on the
+// server's disk are imported with the `models import` command instead, so a
+// request can't make the server read arbitrary paths.
+func handleModelImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	if query.Has("path") {
+		sendJSONError(w, "Importing from a path on the server is not supported; send the model file as the request body, or run `transcriber-pro models import` on the server", http.StatusBadRequest)
+		return
+	}
+
+	opts := ImportOptions{
+		Name:   query.Get("name"),
+		SHA256: query.Get("sha256"),
+	}
+	if value := query.Get("allow_unverified"); value != "" {
+		var err error
+		if opts.AllowUnverified, err = strconv.ParseBool(value); err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid allow_unverified %q (true or false)", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	model, err := engine.Models().ImportReader(http.MaxBytesReader(w, r.Body, engine.Config().MaxUploadSize()), opts)
+	if err != nil {
+		sendJSONError(w, fmt.Sprintf("Import failed: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": []ImportedModel{model},
+	})
+}
+
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := engine.Config()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"config":  cfg,
+		"sources": cfg.Sources(),
+		"file":    cfg.File(),
+	})
+}
+
+func handleWatch(w http.ResponseWriter, r *http.Request) {
+	folders := []WatchFolder{}
+	files := []watchEntry{}
+	if watcher != nil {
+		folders = watcher.Folders()
+		files = watcher.Entries()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"folders": folders,
+		"files":   files,
+	})
+}
+
+func handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deliveries": webhooks.Deliveries(r.URL.Query().Get("job")),
+	})
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ggmlMagic is the first four bytes of every whisper.cpp ggml model file
+const ggmlMagic = 0x67676d6c
+
+// ErrModelNotInstalled is returned for a model that is not on disk
+var ErrModelNotInstalled = errors.New("model is not installed")
+
+// ErrOffline is returned for downloads while offline mode is on
+var ErrOffline = errors.New("downloads are disabled in offline mode")
+
+// ModelInfo describes a whisper.cpp ggml model
+type ModelInfo struct {
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`                   // Approximate download size in bytes
+	EnglishOnly  bool   `json:"english_only"`           // .en models only transcribe English
+	Quantization string `json:"quantization,omitempty"` // q5_0, q5_1 or q8_0 for quantized models
+	SHA256       string `json:"sha256,omitempty"`       // Checksum a download must match
+	Installed    bool   `json:"installed"`
+	Custom       bool   `json:"custom,omitempty"` // Installed but not in the catalog
+}
+
+const mb = 1024 * 1024
+
+// modelCatalog lists the models published at huggingface.co/ggerganov/whisper.cpp.
+// SHA256 is the checksum Hugging Face lists for the file; models without one
+// can only be downloaded once a checksum is recorded in the manifest, e.g. by
+// importing the file with --sha256.
+var modelCatalog = []ModelInfo{
+	{Name: "tiny", Size: 75 * mb, SHA256: "be07e048e1e599ad46341c8d2a135645097a538221678b7acdd1b1919c6e1b21"},
+	{Name: "tiny.en", Size: 75 * mb, EnglishOnly: true, SHA256: "921e4cf8686fdd993dcd081a5da5b6c365bfde1162e72b08d75ac75289920b1f"},
+	{Name: "tiny-q5_1", Size: 31 * mb, Quantization: "q5_1"},
+	{Name: "tiny.en-q5_1", Size: 31 * mb, EnglishOnly: true, Quantization: "q5_1"},
+	{Name: "tiny-q8_0", Size: 42 * mb, Quantization: "q8_0"},
+	{Name: "base", Size: 142 * mb, SHA256: "60ed5bc3dd14eea856493d334349b405782ddcaf0028d4b5df4088345fba2efe"},
+	{Name: "base.en", Size: 142 * mb, EnglishOnly: true, SHA256: "a03779c86df3323075f5e796cb2ce5029f00ec8869eee3fdfb897afe36c6d002"},
+	{Name: "base-q5_1", Size: 57 * mb, Quantization: "q5_1"},
+	{Name: "base.en-q5_1", Size: 57 * mb, EnglishOnly: true, Quantization: "q5_1"},
+	{Name: "base-q8_0", Size: 78 * mb, Quantization: "q8_0"},
+	{Name: "small", Size: 466 * mb, SHA256: "1be3a9b2063867b937e64e2ec7483364a79917e157fa98c5d94b5c1fffea987b"},
+	{Name: "small.en", Size: 466 * mb, EnglishOnly: true, SHA256: "c6138d6d58ecc8322097e0f987c32f1be8bb0a18532a3f88f734d1bbf9c41e5d"},
+	{Name: "small-q5_1", Size: 181 * mb, Quantization: "q5_1"},
+	{Name: "small.en-q5_1", Size: 181 * mb, EnglishOnly: true, Quantization: "q5_1"},
+	{Name: "small-q8_0", Size: 252 * mb, Quantization: "q8_0"},
+	{Name: "medium", Size: 1500 * mb, SHA256: "6c14d5adee5f86394037b4e4e8b59f1673b6cee10e3cf0b11bbdbee79c156208"},
+	{Name: "medium.en", Size: 1500 * mb, EnglishOnly: true, SHA256: "cc37e93478338ec7700281a7ac30a10128929eb8f427dda2e865faa8f6da4356"},
+	{Name: "medium-q5_0", Size: 514 * mb, Quantization: "q5_0"},
+	{Name: "medium.en-q5_0", Size: 514 * mb, EnglishOnly: true, Quantization: "q5_0"},
+	{Name: "medium-q8_0", Size: 785 * mb, Quantization: "q8_0"},
+	{Name: "large-v2", Size: 2900 * mb, SHA256: "9a423fe4d40c82774b6af34115b8b935f34152246eb19e80e376071d3f999487"},
+	{Name: "large-v2-q5_0", Size: 1080 * mb, Quantization: "q5_0"},
+	{Name: "large-v2-q8_0", Size: 1500 * mb, Quantization: "q8_0"},
+	{Name: "large-v3", Size: 2900 * mb, SHA256: "64d182b440b98d5203c4f9bd541544d84c605196c4f7b845dfa11fb23594d1e2"},
+	{Name: "large-v3-q5_0", Size: 1080 * mb, Quantization: "q5_0"},
+	{Name: "large-v3-turbo", Size: 1500 * mb, SHA256: "1fc70f774d38eb169993ac391eea357ef47c88757ef72ee5943879b7e8e2bc69"},
+	{Name: "large-v3-turbo-q5_0", Size: 547 * mb, Quantization: "q5_0"},
+	{Name: "large-v3-turbo-q8_0", Size: 834 * mb, Quantization: "q8_0"},
+}
+
+// catalogModel looks up a model in the catalog
+func catalogModel(name string) (ModelInfo, bool) {
+	for _, info := range modelCatalog {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return ModelInfo{}, false
+}
+
+// ModelManager tracks the ggml models in the model directory
+type ModelManager struct {
+	dir          string
+	defaultModel string
+	offline      bool   // Never touch the network; models must be imported
+	baseURL      string // Catalog models are downloaded from here
+	downloader   *Downloader
+	manifestMu   sync.Mutex
+}
+
+func NewModelManager(cfg *Config) *ModelManager {
+	return &ModelManager{
+		dir:          cfg.ModelDir,
+		defaultModel: cfg.DefaultModel,
+		offline:      cfg.Offline,
+		baseURL:      cfg.ModelURL,
+		downloader:   NewDownloader(),
+	}
+}
+
+// Dir returns the model directory
+func (m *ModelManager) Dir() string {
+	return m.dir
+}
+
+// Offline reports whether downloads are disabled
+func (m *ModelManager) Offline() bool {
+	return m.offline
+}
+
+// Downloads returns the state of every model download since startup
+func (m *ModelManager) Downloads() []DownloadStatus {
+	return m.downloader.Status()
+}
+
+// Default returns the name of the model used when a job doesn't pick one
+func (m *ModelManager) Default() string {
+	return m.defaultModel
+}
+
+// Path returns where the named model lives on disk
+func (m *ModelManager) Path(name string) string {
+	return filepath.Join(m.dir, "ggml-"+name+".bin")
+}
+
+// IsInstalled reports whether the named model is on disk
+func (m *ModelManager) IsInstalled(name string) bool {
+	stat, err := os.Stat(m.Path(name))
+	return err == nil && stat.Mode().IsRegular()
+}
+
+// Resolve validates a model name requested by a job and returns the name to
+// use. An empty name selects the default model.
+func (m *ModelManager) Resolve(name string) (string, error) {
+	if name == "" {
+		name = m.defaultModel
+	}
+	if !validModelName(name) {
+		return "", fmt.Errorf("invalid model name %q", name)
+	}
+	if !m.IsInstalled(name) {
+		installed := m.installedNames()
+		if len(installed) == 0 {
+			return "", fmt.Errorf("%w: %q (no models installed)", ErrModelNotInstalled, name)
+		}
+		return "", fmt.Errorf("%w: %q (installed: %s)", ErrModelNotInstalled, name, strings.Join(installed, ", "))
+	}
+	return name, nil
+}
+
+// validModelName rejects names that would escape the model directory
+func validModelName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && !strings.Contains(name, "..")
+}
+
+// List returns the catalog with install state, followed by any other ggml
+// models found in the model directory
+func (m *ModelManager) List() []ModelInfo {
+	models := make([]ModelInfo, 0, len(modelCatalog))
+	known := make(map[string]bool)
+	for _, info := range modelCatalog {
+		info.Installed = m.IsInstalled(info.Name)
+		models = append(models, info)
+		known[info.Name] = true
+	}
+
+	for _, name := range m.installedNames() {
+		if known[name] {
+			continue
+		}
+		info := ModelInfo{Name: name, Installed: true, Custom: true}
+		if stat, err := os.Stat(m.Path(name)); err == nil {
+			info.Size = stat.Size()
+		}
+		models = append(models, info)
+	}
+
+	return models
+}
+
+// installedNames returns the names of all models in the model directory
+func (m *ModelManager) installedNames() []string {
+	matches, _ := filepath.Glob(filepath.Join(m.dir, "ggml-*.bin"))
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), "ggml-"), ".bin")
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ensureDefault makes sure a default model is available. A missing or damaged
+// default model is downloaded; if that is not possible (offline mode, or the
+// download fails) the largest installed model becomes the default instead.
+func (m *ModelManager) ensureDefault() error {
+	err := m.ensureModel(m.defaultModel)
+	if err == nil {
+		return nil
+	}
+
+	fallback := m.largestInstalled()
+	if fallback == "" {
+		return fmt.Errorf("%w; import a model with `transcriber-pro models import <file>`", err)
+	}
+	log.Printf("Warning: %v; using installed model %s as the default", err, fallback)
+	m.defaultModel = fallback
+	return nil
+}
+
+// largestInstalled returns the installed model with the biggest file, which
+// is usually the most accurate one
+func (m *ModelManager) largestInstalled() string {
+	best, bestSize := "", int64(-1)
+	for _, name := range m.installedNames() {
+		if checkModelFile(m.Path(name)) != nil {
+			continue
+		}
+		if stat, err := os.Stat(m.Path(name)); err == nil && stat.Size() > bestSize {
+			best, bestSize = name, stat.Size()
+		}
+	}
+	return best
+}
+
+// ensureModel makes sure the named model is installed, downloading it if it
+// is missing or doesn't match its manifest entry
+func (m *ModelManager) ensureModel(name string) error {
+	if !validModelName(name) {
+		return fmt.Errorf("invalid default model name %q", name)
+	}
+	modelPath := m.Path(name)
+
+	// Check if model file exists and validate it
+	needsDownload := false
+	if stat, err := os.Stat(modelPath); os.IsNotExist(err) {
+		needsDownload = true
+	} else if err != nil {
+		return fmt.Errorf("failed to check model %s: %w", name, err)
+	} else if err := checkModelFile(modelPath); err != nil {
+		log.Printf("Model file is invalid (%v). Removing and re-downloading...", err)
+		os.Remove(modelPath)
+		needsDownload = true
+	} else if entry, ok := m.manifestEntry(name); ok && entry.Size > 0 && stat.Size() != entry.Size {
+		log.Printf("Model file does not match its manifest (size: %d bytes, expected: %d bytes). Removing and re-downloading...", stat.Size(), entry.Size)
+		os.Remove(modelPath)
+		needsDownload = true
+	} else if !ok {
+		// Installed before checksums were tracked; record what is on disk so
+		// later changes to the file are noticed
+		sum, err := fileSHA256(modelPath)
+		if err != nil {
+			return fmt.Errorf("failed to checksum model %s: %w", name, err)
+		}
+		log.Printf("Recording checksum of existing %s model: %s", name, sum)
+		if err := m.recordManifest(name, manifestEntry{SHA256: sum, Size: stat.Size()}); err != nil {
+			log.Printf("Warning: failed to update model manifest: %v", err)
+		}
+	}
+
+	if !needsDownload {
+		return nil
+	}
+	if _, known := catalogModel(name); !known {
+		return fmt.Errorf("default model %q is not installed and is not a known download", name)
+	}
+	if m.offline {
+		return fmt.Errorf("default model %q is not installed and %w", name, ErrOffline)
+	}
+	return m.Download(context.Background(), name)
+}
+
+// Download fetches a catalog model into the model directory. The file must
+// match the checksum from expectedSHA, and is recorded in the manifest on
+// success.
+func (m *ModelManager) Download(ctx context.Context, name string) error {
+	info, known := catalogModel(name)
+	if !known {
+		return fmt.Errorf("unknown model %q", name)
+	}
+	if m.offline {
+		return ErrOffline
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create model directory: %w", err)
+	}
+
+	log.Printf("Downloading Whisper %s model (~%d MB)...", name, info.Size/mb)
+	sum, err := m.downloader.Download(ctx, name, m.modelURL(name), m.Path(name), m.expectedSHA(info))
+	if err != nil {
+		return fmt.Errorf("failed to download model %s: %w", name, err)
+	}
+
+	stat, err := os.Stat(m.Path(name))
+	if err != nil {
+		return err
+	}
+	if err := m.recordManifest(name, manifestEntry{SHA256: sum, Size: stat.Size()}); err != nil {
+		log.Printf("Warning: failed to update model manifest: %v", err)
+	}
+	return nil
+}
+
+// StartDownload validates the request and downloads the model in the
+// background. Progress is reported by Downloads.
+func (m *ModelManager) StartDownload(name string) error {
+	if !validModelName(name) {
+		return fmt.Errorf("invalid model name %q", name)
+	}
+	if _, known := catalogModel(name); !known {
+		return fmt.Errorf("unknown model %q", name)
+	}
+	if m.offline {
+		return ErrOffline
+	}
+	if info, _ := catalogModel(name); m.expectedSHA(info) == "" {
+		return fmt.Errorf("%w for model %s; download it yourself and install it with `transcriber-pro models import --sha256`", ErrNoChecksum, name)
+	}
+	if m.downloader.IsRunning(name) {
+		return fmt.Errorf("%w: %s", ErrDownloadInProgress, name)
+	}
+
+	go func() {
+		if err := m.Download(context.Background(), name); err != nil {
+			log.Printf("[Download %s] %v", name, err)
+		}
+	}()
+	return nil
+}
+
+// expectedSHA returns the checksum a download of a catalog model must match:
+// the catalog's, or for models the catalog has none for, the one recorded in
+// the manifest
+func (m *ModelManager) expectedSHA(info ModelInfo) string {
+	if info.SHA256 != "" {
+		return info.SHA256
+	}
+	entry, _ := m.manifestEntry(info.Name)
+	return entry.SHA256
+}
+
+// modelURL returns the download URL for a catalog model
+func (m *ModelManager) modelURL(name string) string {
+	return strings.TrimSuffix(m.baseURL, "/") + "/ggml-" + name + ".bin"
+}
+
+// manifestEntry is the known checksum of a model file
+type manifestEntry struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+func (m *ModelManager) manifestPath() string {
+	return filepath.Join(m.dir, "manifest.json")
+}
+
+// loadManifest reads manifest.json from the model directory. A missing
+// manifest is empty.
+func (m *ModelManager) loadManifest() (map[string]manifestEntry, error) {
+	manifest, err := readManifestFile(m.manifestPath())
+	if os.IsNotExist(err) {
+		return make(map[string]manifestEntry), nil
+	}
+	return manifest, err
+}
+
+// readManifestFile parses a manifest mapping model names to checksums
+func readManifestFile(path string) (map[string]manifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := make(map[string]manifestEntry)
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+func (m *ModelManager) manifestEntry(name string) (manifestEntry, bool) {
+	m.manifestMu.Lock()
+	defer m.manifestMu.Unlock()
+
+	manifest, err := m.loadManifest()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return manifestEntry{}, false
+	}
+	entry, ok := manifest[name]
+	return entry, ok
+}
+
+// recordManifest stores the checksum of a model, replacing manifest.json
+// atomically
+func (m *ModelManager) recordManifest(name string, entry manifestEntry) error {
+	m.manifestMu.Lock()
+	defer m.manifestMu.Unlock()
+
+	manifest, err := m.loadManifest()
+	if err != nil {
+		return err
+	}
+	manifest[name] = entry
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := m.manifestPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, m.manifestPath())
+}
+
+// checkModelFile verifies that path starts with the ggml magic number, which
+// catches truncated downloads and files that are not whisper models
+func checkModelFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return checkModelHeader(file, filepath.Base(path))
+}
+
+// checkModelHeader reads the ggml magic number from r; label names the file
+// in errors
+func checkModelHeader(r io.Reader, label string) error {
+	var magic uint32
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%s is too short to be a ggml model", label)
+		}
+		return err
+	}
+	if magic != ggmlMagic {
+		return fmt.Errorf("%s is not a ggml model (bad magic 0x%08x)", label, magic)
+	}
+	return nil
+}
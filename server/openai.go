@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OpenAI-compatible audio endpoints. Requests go through the same queue as
+// uploads from the web UI, and the response is written once the job
+// finishes. A client that disconnects first cancels its job.
+
+// openAIModel is the model name OpenAI clients send; it maps to the server's
+// default model
+const openAIModel = "whisper-1"
+
+// openAIFormats are the accepted response_format values
+var openAIFormats = map[string]bool{"json": true, "text": true, "srt": true, "verbose_json": true, "vtt": true}
+
+// openAIError is the error body OpenAI clients expect
+type openAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// openAIVerbose is the verbose_json response
+type openAIVerbose struct {
+	Task     string          `json:"task"`
+	Language string          `json:"language"`
+	Duration float64         `json:"duration"`
+	Text     string          `json:"text"`
+	Segments []openAISegment `json:"segments,omitempty"`
+	Words    []openAIWord    `json:"words,omitempty"`
+}
+
+// openAIWord is a verbose_json word, returned for the "word" granularity
+type openAIWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// openAISegment is a verbose_json segment. whisper.cpp doesn't report the
+// decoder statistics, so those fields are zero.
+type openAISegment struct {
+	ID               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+}
+
+func handleOpenAITranscriptions(w http.ResponseWriter, r *http.Request) {
+	handleOpenAIAudio(w, r, false)
+}
+
+func handleOpenAITranslations(w http.ResponseWriter, r *http.Request) {
+	handleOpenAIAudio(w, r, true)
+}
+
+// handleOpenAIAudio serves /v1/audio/transcriptions and, with translate set,
+// /v1/audio/translations
+func handleOpenAIAudio(w http.ResponseWriter, r *http.Request, translate bool) {
+	if r.Method != http.MethodPost {
+		sendOpenAIError(w, http.StatusMethodNotAllowed, "Method not allowed", "", "")
+		return
+	}
+
+	cfg := engine.Config()
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxUploadSize())
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		sendOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Failed to parse upload: %v", err), "", "")
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		sendOpenAIError(w, http.StatusBadRequest, "No audio file provided", "file", "")
+		return
+	}
+	defer file.Close()
+
+	format := r.FormValue("response_format")
+	if format == "" {
+		format = "json"
+	}
+	if !openAIFormats[format] {
+		sendOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported response_format %q", format), "response_format", "")
+		return
+	}
+
+	model := r.FormValue("model")
+	if model == openAIModel {
+		model = ""
+	}
+	model, err = engine.Models().Resolve(model)
+	if err != nil {
+		sendOpenAIError(w, http.StatusBadRequest, err.Error(), "model", "model_not_found")
+		return
+	}
+
+	language := r.FormValue("language")
+	if language == "" {
+		language = cfg.DefaultLanguage
+	} else if _, ok := whisperLanguages[language]; !ok && language != "auto" {
+		sendOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported language %q", language), "language", "")
+		return
+	}
+
+	var temperature float64
+	if value := r.FormValue("temperature"); value != "" {
+		temperature, err = strconv.ParseFloat(value, 32)
+		if err != nil || temperature < 0 || temperature > 1 {
+			sendOpenAIError(w, http.StatusBadRequest, "temperature must be between 0 and 1", "temperature", "")
+			return
+		}
+	}
+
+	// Clients send the list as timestamp_granularities[], the form encoding
+	// of an array. Without it, verbose_json has segments only.
+	var granularities []string
+	if r.MultipartForm != nil {
+		granularities = append(r.MultipartForm.Value["timestamp_granularities[]"], r.MultipartForm.Value["timestamp_granularities"]...)
+	}
+	want := map[string]bool{"segment": len(granularities) == 0}
+	for _, g := range granularities {
+		if g != "segment" && g != "word" {
+			sendOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported timestamp granularity %q", g), "timestamp_granularities", "")
+			return
+		}
+		want[g] = true
+	}
+	if len(granularities) > 0 && format != "verbose_json" {
+		sendOpenAIError(w, http.StatusBadRequest, "timestamp_granularities requires response_format verbose_json", "timestamp_granularities", "")
+		return
+	}
+
+	jobID := uuid.New().String()
+	audioPath := filepath.Join(cfg.UploadDir, jobID+filepath.Ext(header.Filename))
+	dst, err := os.Create(audioPath)
+	if err != nil {
+		sendOpenAIError(w, http.StatusInternalServerError, "Failed to save file", "", "")
+		return
+	}
+	_, err = io.Copy(dst, file)
+	dst.Close()
+	if err != nil {
+		os.Remove(audioPath)
+		sendOpenAIError(w, http.StatusInternalServerError, "Failed to save file", "", "")
+		return
+	}
+
+	engine.CreateJob(jobID, header.Filename, audioPath, JobOptions{
+		Language:       language,
+		Model:          model,
+		Prompt:         r.FormValue("prompt"),
+		Temperature:    float32(temperature),
+		Translate:      translate,
+		WordTimestamps: want["word"],
+	})
+
+	job, err := waitForJob(r.Context(), jobID)
+	if r.Context().Err() != nil {
+		// The client went away; nobody is waiting for the result
+		log.Printf("[OpenAI] Client disconnected, cancelling job %s", jobID)
+		engine.CancelJob(jobID)
+		return
+	}
+	if err != nil {
+		sendOpenAIError(w, http.StatusInternalServerError, "The job was removed before it finished", "", "")
+		return
+	}
+	if job.Status != StatusCompleted || job.Result == nil {
+		sendOpenAIError(w, http.StatusInternalServerError, job.Error, "", "transcription_failed")
+		return
+	}
+
+	writeOpenAIResult(w, job.Result, format, translate, temperature, want)
+}
+
+// waitForJob blocks until the job completes or fails, or ctx is done
+func waitForJob(ctx context.Context, jobID string) (*Job, error) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		job := engine.GetJob(jobID)
+		if job == nil {
+			return nil, ErrJobNotFound
+		}
+		if job.Status == StatusCompleted || job.Status == StatusFailed {
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeOpenAIResult writes result in an OpenAI response format. granularities
+// selects the segments and words of verbose_json.
+func writeOpenAIResult(w http.ResponseWriter, result *TranscriptionResult, format string, translate bool, temperature float64, granularities map[string]bool) {
+	text := strings.TrimSpace(result.Text)
+
+	switch format {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, text)
+
+	case "srt", "vtt":
+		data, err := renderExport(result, format, ExportOptions{VTT: VTTOptions{OmitNote: true}})
+		if err != nil {
+			sendOpenAIError(w, http.StatusInternalServerError, err.Error(), "", "")
+			return
+		}
+		w.Header().Set("Content-Type", exportFormats[format].ContentType)
+		w.Write(data)
+
+	case "verbose_json":
+		task := "transcribe"
+		language := result.Language
+		if translate {
+			task = "translate"
+			language = "en"
+		}
+		if name, ok := whisperLanguages[language]; ok {
+			language = name
+		}
+
+		verbose := openAIVerbose{
+			Task:     task,
+			Language: language,
+			Duration: result.Duration,
+			Text:     text,
+		}
+		for i, segment := range result.Segments {
+			if granularities["segment"] {
+				verbose.Segments = append(verbose.Segments, openAISegment{
+					ID:          i,
+					Seek:        int(math.Round(segment.Start * 100)),
+					Start:       segment.Start,
+					End:         segment.End,
+					Text:        segment.Text,
+					Tokens:      []int{},
+					Temperature: temperature,
+				})
+			}
+			if granularities["word"] {
+				for _, word := range segment.Words {
+					verbose.Words = append(verbose.Words, openAIWord{Word: word.Text, Start: word.Start, End: word.End})
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(verbose)
+
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"text": text})
+	}
+}
+
+// sendOpenAIError writes an error in the shape of the OpenAI API
+func sendOpenAIError(w http.ResponseWriter, statusCode int, message, param, code string) {
+	body := openAIError{Message: message, Type: "invalid_request_error"}
+	if statusCode >= 500 {
+		body.Type = "server_error"
+	}
+	if param != "" {
+		body.Param = &param
+	}
+	if code != "" {
+		body.Code = &code
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]openAIError{"error": body})
+}
+
+// whisperLanguages maps the language codes Whisper understands to the names
+// OpenAI reports in verbose_json
+var whisperLanguages = map[string]string{
+	"en": "english", "zh": "chinese", "de": "german", "es": "spanish", "ru": "russian",
+	"ko": "korean", "fr": "french", "ja": "japanese", "pt": "portuguese", "tr": "turkish",
+	"pl": "polish", "ca": "catalan", "nl": "dutch", "ar": "arabic", "sv": "swedish",
+	"it": "italian", "id": "indonesian", "hi": "hindi", "fi": "finnish", "vi": "vietnamese",
+	"he": "hebrew", "uk": "ukrainian", "el": "greek", "ms": "malay", "cs": "czech",
+	"ro": "romanian", "da": "danish", "hu": "hungarian", "ta": "tamil", "no": "norwegian",
+	"th": "thai", "ur": "urdu", "hr": "croatian", "bg": "bulgarian", "lt": "lithuanian",
+	"la": "latin", "mi": "maori", "ml": "malayalam", "cy": "welsh", "sk": "slovak",
+	"te": "telugu", "fa": "persian", "lv": "latvian", "bn": "bengali", "sr": "serbian",
+	"az": "azerbaijani", "sl": "slovenian", "kn": "kannada", "et": "estonian", "mk": "macedonian",
+	"br": "breton", "eu": "basque", "is": "icelandic", "hy": "armenian", "ne": "nepali",
+	"mn": "mongolian", "bs": "bosnian", "kk": "kazakh", "sq": "albanian", "sw": "swahili",
+	"gl": "galician", "mr": "marathi", "pa": "punjabi", "si": "sinhala", "km": "khmer",
+	"sn": "shona", "yo": "yoruba", "so": "somali", "af": "afrikaans", "oc": "occitan",
+	"ka": "georgian", "be": "belarusian", "tg": "tajik", "sd": "sindhi", "gu": "gujarati",
+	"am": "amharic", "yi": "yiddish", "lo": "lao", "uz": "uzbek", "fo": "faroese",
+	"ht": "haitian creole", "ps": "pashto", "tk": "turkmen", "nn": "nynorsk", "mt": "maltese",
+	"sa": "sanskrit", "lb": "luxembourgish", "my": "myanmar", "bo": "tibetan", "tl": "tagalog",
+	"mg": "malagasy", "as": "assamese", "tt": "tatar", "haw": "hawaiian", "ln": "lingala",
+	"ha": "hausa", "ba": "bashkir", "jw": "javanese", "su": "sundanese", "yue": "cantonese",
+}
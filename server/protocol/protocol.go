@@ -0,0 +1,212 @@
+// Package protocol defines the messages exchanged between the server and
+// transcriber-worker processes. Messages are newline-delimited JSON frames:
+// the server writes to the worker's stdin and reads the worker's stdout.
+//
+// A session starts with a handshake. The server sends a hello frame carrying
+// its protocol version, and the worker answers with its own hello, or with an
+// error frame if the versions differ. The server then sends request frames;
+// for each one the worker streams progress, segment and log frames and
+// finishes with a single result or error frame. A worker serves requests
+// sequentially until the server closes its stdin.
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Version is the protocol version. Bump it on any incompatible change to the
+// messages below.
+const Version = 4
+
+// MessageType identifies the payload carried by a Message
+type MessageType string
+
+const (
+	TypeHello    MessageType = "hello"    // Both directions, first frame of a session
+	TypeRequest  MessageType = "request"  // Server to worker: transcribe a file
+	TypeProgress MessageType = "progress" // Worker to server: stage or percent update
+	TypeSegment  MessageType = "segment"  // Worker to server: newly decoded segment
+	TypeLog      MessageType = "log"      // Worker to server: log line
+	TypeResult   MessageType = "result"   // Worker to server: final transcription
+	TypeError    MessageType = "error"    // Worker to server: request or session failed
+)
+
+// Message is a single protocol frame. Exactly one payload field matching Type
+// is set.
+type Message struct {
+	Type     MessageType `json:"type"`
+	JobID    string      `json:"job_id,omitempty"`
+	Hello    *Hello      `json:"hello,omitempty"`
+	Request  *Request    `json:"request,omitempty"`
+	Progress *Progress   `json:"progress,omitempty"`
+	Segment  *Segment    `json:"segment,omitempty"`
+	Log      *Log        `json:"log,omitempty"`
+	Result   *Result     `json:"result,omitempty"`
+	Error    *Error      `json:"error,omitempty"`
+}
+
+// Hello opens a session and announces the sender's protocol version
+type Hello struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	Version         string `json:"version,omitempty"` // Build version of the sender
+}
+
+// Request asks the worker to transcribe an audio file
+type Request struct {
+	AudioPath      string  `json:"audio_path"`
+	ModelPath      string  `json:"model_path"`
+	Language       string  `json:"language"`
+	Threads        int     `json:"threads,omitempty"`
+	InitialPrompt  string  `json:"initial_prompt,omitempty"`  // Text that primes the decoder, such as names and jargon
+	Temperature    float32 `json:"temperature,omitempty"`     // Sampling temperature; 0 is greedy decoding
+	Translate      bool    `json:"translate,omitempty"`       // Translate the speech to English
+	WordTimestamps bool    `json:"word_timestamps,omitempty"` // Time every word of every segment
+	Channel        int     `json:"channel,omitempty"`         // Transcribe only this channel, counting from 1; 0 mixes all channels
+}
+
+// Progress reports what the worker is doing. Percent is only meaningful once
+// Stage is StageTranscribing.
+type Progress struct {
+	Stage   string  `json:"stage"`
+	Percent float64 `json:"percent"`
+}
+
+// Stages reported in Progress frames
+const (
+	StageLoadingModel  = "loading_model"
+	StageDecodingAudio = "decoding_audio"
+	StageTranscribing  = "transcribing"
+)
+
+// Segment is a single segment of transcribed text
+type Segment struct {
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Text    string  `json:"text"`
+	Speaker string  `json:"speaker,omitempty"` // Set when speakers have been identified
+	Words   []Word  `json:"words,omitempty"`   // Set when word timestamps were requested
+}
+
+// Word is a single word of a segment with its timing. Probability is the
+// decoder's mean confidence in the word's tokens, from 0 to 1.
+type Word struct {
+	Text        string  `json:"text"`
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+	Probability float64 `json:"probability"`
+}
+
+// Log carries a worker log line
+type Log struct {
+	Message string `json:"message"`
+}
+
+// Result is the final transcription for a request
+type Result struct {
+	Text     string    `json:"text"`
+	Segments []Segment `json:"segments"`
+	Language string    `json:"language,omitempty"` // Language detected or used for decoding
+	Duration float64   `json:"duration"`           // Processing time in seconds
+	MaxRSS   int64     `json:"max_rss,omitempty"`  // Peak resident memory of the worker in bytes
+}
+
+// Error reports a failed request or a failed handshake
+type Error struct {
+	Message string `json:"message"`
+}
+
+// ErrInvalidFrame is returned by Decoder.Next for a line that is not a
+// protocol frame, such as stray output from a library. The session is still
+// usable and the caller may keep reading.
+var ErrInvalidFrame = errors.New("invalid protocol frame")
+
+// VersionError reports a handshake with a peer speaking another protocol
+// version
+type VersionError struct {
+	Local, Remote int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("protocol version mismatch: local %d, remote %d", e.Local, e.Remote)
+}
+
+// CheckHello validates the hello frame that opens a session
+func CheckHello(msg *Message) error {
+	if msg.Type == TypeError && msg.Error != nil {
+		return fmt.Errorf("handshake rejected: %s", msg.Error.Message)
+	}
+	if msg.Type != TypeHello || msg.Hello == nil {
+		return fmt.Errorf("handshake failed: expected hello frame, got %q", msg.Type)
+	}
+	if msg.Hello.ProtocolVersion != Version {
+		return &VersionError{Local: Version, Remote: msg.Hello.ProtocolVersion}
+	}
+	return nil
+}
+
+// NewHello returns the hello frame for this side of a session
+func NewHello(version string) Message {
+	return Message{Type: TypeHello, Hello: &Hello{ProtocolVersion: Version, Version: version}}
+}
+
+// Encoder writes frames to a stream. It is safe for concurrent use, so
+// callbacks running on other threads can report progress directly.
+type Encoder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Send writes msg as a single line
+func (e *Encoder) Send(msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s frame: %w", msg.Type, err)
+	}
+	data = append(data, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(data)
+	return err
+}
+
+// Decoder reads frames from a stream
+type Decoder struct {
+	r *bufio.Reader
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Next returns the next frame. Blank lines are skipped; a line that is not a
+// frame yields an error wrapping ErrInvalidFrame. At the end of the stream it
+// returns io.EOF.
+func (d *Decoder) Next() (*Message, error) {
+	for {
+		line, err := d.r.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var msg Message
+		if jsonErr := json.Unmarshal(line, &msg); jsonErr != nil || msg.Type == "" {
+			return nil, fmt.Errorf("%w: %.200q", ErrInvalidFrame, line)
+		}
+		return &msg, nil
+	}
+}
@@ -13,6 +13,9 @@ class WhisperApp {
         this.activeJobs = new Map(); // Map of job_id -> file info
         this.completedJobs = []; // Array of completed transcriptions
         this.queuePollInterval = null;
+        this.eventSource = null; // Live updates from /events, null while polling
+        this.jobs = new Map(); // Server jobs by ID, kept current from the event stream
+        this.renderPending = false;
         this.selectedJobId = null; // Currently selected/viewed job
         this.queueLoaded = false; // Track if queue has been loaded at least once
 
@@ -31,6 +34,10 @@ class WhisperApp {
             dropZone: document.getElementById('dropZone'),
             fileInput: document.getElementById('fileInput'),
             languageSelect: document.getElementById('languageSelect'),
+            modelSelect: document.getElementById('modelSelect'),
+            taskSelect: document.getElementById('taskSelect'),
+            vocabularySelect: document.getElementById('vocabularySelect'),
+            promptInput: document.getElementById('promptInput'),
 
             processingFileName: document.getElementById('processingFileName'),
             processingStatus: document.getElementById('processingStatus'),
@@ -53,7 +60,9 @@ class WhisperApp {
             copyBtn: document.getElementById('copyBtn'),
             exportTxt: document.getElementById('exportTxt'),
             exportSrt: document.getElementById('exportSrt'),
-            exportJson: document.getElementById('exportJson')
+            exportVtt: document.getElementById('exportVtt'),
+            exportJson: document.getElementById('exportJson'),
+            exportZip: document.getElementById('exportZip')
         };
 
         this.init();
@@ -69,8 +78,14 @@ class WhisperApp {
         // Fetch and display version
         await this.fetchVersion();
 
-        // Start queue polling
-        this.startQueuePolling();
+        // Populate the model selector with installed models
+        await this.fetchModels();
+
+        // Offer the vocabularies stored on the server
+        await this.fetchVocabularies();
+
+        // Follow the queue over the event stream, or poll if it's unavailable
+        this.startQueueUpdates();
     }
 
     setupEventListeners() {
@@ -126,6 +141,14 @@ class WhisperApp {
             this.exportAs('srt');
         });
 
+        this.elements.exportVtt.addEventListener('click', () => {
+            this.exportAs('vtt');
+        });
+
+        this.elements.exportZip.addEventListener('click', () => {
+            this.exportAs('zip');
+        });
+
         this.elements.exportJson.addEventListener('click', () => {
             this.exportAs('json');
         });
@@ -167,6 +190,48 @@ class WhisperApp {
         }
     }
 
+    async fetchModels() {
+        try {
+            const response = await fetch('/models');
+            if (!response.ok) return;
+
+            const data = await response.json();
+            const select = this.elements.modelSelect;
+            if (!select) return;
+
+            (data.models || [])
+                .filter(model => model.installed)
+                .forEach(model => {
+                    const option = document.createElement('option');
+                    option.value = model.name;
+                    option.textContent = model.name === data.default ? `${model.name} (default)` : model.name;
+                    select.appendChild(option);
+                });
+        } catch (error) {
+            console.error('[WhisperApp] Failed to fetch models:', error);
+        }
+    }
+
+    async fetchVocabularies() {
+        try {
+            const response = await fetch('/vocabularies');
+            if (!response.ok) return;
+
+            const data = await response.json();
+            const select = this.elements.vocabularySelect;
+            if (!select) return;
+
+            (data.vocabularies || []).forEach(vocabulary => {
+                const option = document.createElement('option');
+                option.value = vocabulary.name;
+                option.textContent = `${vocabulary.name} (${vocabulary.terms.length} terms)`;
+                select.appendChild(option);
+            });
+        } catch (error) {
+            console.error('[WhisperApp] Failed to fetch vocabularies:', error);
+        }
+    }
+
     async onCompanionConnected(info) {
         console.log('[WhisperApp] Companion connected:', info);
 
@@ -226,6 +291,26 @@ class WhisperApp {
                 formData.append('language', language);
             }
 
+            const model = this.elements.modelSelect ? this.elements.modelSelect.value : '';
+            if (model) {
+                formData.append('model', model);
+            }
+
+            const task = this.elements.taskSelect ? this.elements.taskSelect.value : '';
+            if (task) {
+                formData.append('task', task);
+            }
+
+            const vocabulary = this.elements.vocabularySelect ? this.elements.vocabularySelect.value : '';
+            if (vocabulary) {
+                formData.append('vocabulary', vocabulary);
+            }
+
+            const prompt = this.elements.promptInput ? this.elements.promptInput.value.trim() : '';
+            if (prompt) {
+                formData.append('initial_prompt', prompt);
+            }
+
             console.log('[WhisperApp] Uploading:', file.name);
 
             // Upload file and get job ID
@@ -239,16 +324,18 @@ class WhisperApp {
                 throw new Error(error.error || 'Failed to start transcription');
             }
 
-            const { job_id } = await response.json();
+            const { job_id, translation_job_id } = await response.json();
             console.log('[WhisperApp] Job created:', job_id, file.name);
 
-            // Store job info
-            this.activeJobs.set(job_id, {
-                id: job_id,
-                fileName: file.name,
-                status: 'queued',
-                progress: 0
-            });
+            // Store job info; "both" adds a linked translation job
+            for (const id of [job_id, translation_job_id].filter(Boolean)) {
+                this.activeJobs.set(id, {
+                    id: id,
+                    fileName: file.name,
+                    status: 'queued',
+                    progress: 0
+                });
+            }
 
         } catch (error) {
             console.error('[WhisperApp] Upload error:', error);
@@ -256,6 +343,72 @@ class WhisperApp {
         }
     }
 
+    startQueueUpdates() {
+        if (!window.EventSource) {
+            this.startQueuePolling();
+            return;
+        }
+
+        this.eventSource = new EventSource(`${this.serverUrl}/events`);
+
+        // The server sends the whole queue first, then only what changed
+        this.eventSource.addEventListener('snapshot', (e) => {
+            const data = JSON.parse(e.data);
+            this.jobs.clear();
+            [...(data.queue || []), ...(data.completed || [])].forEach(job => this.jobs.set(job.ID, job));
+            this.scheduleRender();
+        });
+
+        ['queued', 'started', 'updated', 'completed', 'failed', 'cancelled', 'killed'].forEach(type => {
+            this.eventSource.addEventListener(type, (e) => {
+                const data = JSON.parse(e.data);
+                this.jobs.set(data.job_id, Object.assign(this.jobs.get(data.job_id) || {}, data.job));
+                this.scheduleRender();
+            });
+        });
+
+        this.eventSource.addEventListener('removed', (e) => {
+            this.jobs.delete(JSON.parse(e.data).job_id);
+            this.scheduleRender();
+        });
+
+        this.eventSource.onerror = () => {
+            // EventSource reconnects by itself and gets a fresh snapshot; only
+            // fall back to polling if it gave up
+            if (this.eventSource.readyState === EventSource.CLOSED) {
+                console.warn('[WhisperApp] Event stream closed, falling back to polling');
+                this.eventSource = null;
+                this.startQueuePolling();
+            }
+        };
+    }
+
+    scheduleRender() {
+        // Coalesce bursts of events into one render per frame
+        if (this.renderPending) return;
+        this.renderPending = true;
+        requestAnimationFrame(() => {
+            this.renderPending = false;
+            this.renderJobs();
+        });
+    }
+
+    renderJobs() {
+        const active = ['queued', 'processing', 'transcribing'];
+        const jobs = Array.from(this.jobs.values());
+
+        // Jobs being transcribed first, then by queue position
+        const queue = jobs
+            .filter(job => active.includes(job.Status))
+            .sort((a, b) => (a.QueuePosition || 0) - (b.QueuePosition || 0) ||
+                new Date(a.CreatedAt) - new Date(b.CreatedAt));
+        const completed = jobs
+            .filter(job => !active.includes(job.Status))
+            .sort((a, b) => (a.ID < b.ID ? -1 : a.ID > b.ID ? 1 : 0));
+
+        this.renderQueue(queue, completed);
+    }
+
     startQueuePolling() {
         // Poll queue status every second
         this.queuePollInterval = setInterval(async () => {
@@ -264,6 +417,11 @@ class WhisperApp {
     }
 
     async updateQueueStatus() {
+        if (this.eventSource) {
+            this.renderJobs();
+            return;
+        }
+
         try {
             const response = await fetch(`${this.serverUrl}/queue`);
             if (!response.ok) return;
@@ -360,13 +518,13 @@ class WhisperApp {
         item.dataset.jobId = job.ID; // Important: track by ID
 
         const statusBadge = this.getStatusBadge(job.Status);
-        const isProcessing = index === 0 && (job.Status === 'processing' || job.Status === 'transcribing');
+        const isProcessing = job.Status === 'processing' || job.Status === 'transcribing';
         const canCancel = job.Status === 'queued' || isProcessing;
 
         item.innerHTML = `
             <div class="queue-item-header">
                 <span class="queue-position">#${index + 1}</span>
-                <span class="queue-filename">${job.FileName}</span>
+                <span class="queue-filename">${job.FileName}${job.Translate ? ' (English)' : ''}</span>
                 <span class="queue-status-badge ${statusBadge.class}">${statusBadge.text}</span>
                 ${canCancel ? `<button class="cancel-job-btn" data-job-id="${job.ID}" title="Cancel">✕</button>` : ''}
             </div>
@@ -390,8 +548,12 @@ class WhisperApp {
                 e.stopPropagation();
                 const jobId = e.target.dataset.jobId;
                 // Check job status at click time, not creation time
-                // Always use killJob for actively processing jobs to ensure worker is killed
-                await this.killJob(jobId);
+                // Always use killJob for actively processing jobs to ensure worker is killed;
+                // the server refuses to kill jobs that are not running, so cancel those instead
+                const killed = await this.killJob(jobId);
+                if (!killed) {
+                    await this.cancelJob(jobId);
+                }
             });
         }
 
@@ -516,7 +678,7 @@ class WhisperApp {
         item.innerHTML = `
             <div class="queue-item-header">
                 <span class="queue-position">${icon}</span>
-                <span class="queue-filename">${job.FileName}</span>
+                <span class="queue-filename">${job.FileName}${job.Translate ? ' (English)' : ''}</span>
                 <span class="queue-status-badge ${statusBadge.class}">${statusBadge.text}</span>
             </div>
             ${job.Error ? `<div class="queue-error">${job.Error}</div>` : ''}
@@ -617,15 +779,20 @@ class WhisperApp {
             });
 
             if (!response.ok) {
-                throw new Error('Failed to cancel job');
+                const error = await response.json().catch(() => ({}));
+                throw new Error(error.error || `Server returned ${response.status}`);
             }
 
             console.log(`[WhisperApp] Job ${jobId} cancelled`);
         } catch (error) {
             console.error('[WhisperApp] Failed to cancel job:', error);
+            alert(`Failed to cancel job: ${error.message}`);
         }
     }
 
+    // killJob returns whether the job's worker was killed. Any failure,
+    // including a 409 for a job that isn't running, returns false so the
+    // caller falls back to cancelling it.
     async killJob(jobId) {
         try {
             const response = await fetch(`${this.serverUrl}/kill-job/${jobId}`, {
@@ -633,12 +800,17 @@ class WhisperApp {
             });
 
             if (!response.ok) {
-                throw new Error('Failed to kill job');
+                if (response.status !== 409) {
+                    console.warn(`[WhisperApp] Kill of job ${jobId} failed with status ${response.status}`);
+                }
+                return false;
             }
 
             console.log(`[WhisperApp] Job ${jobId} killed`);
+            return true;
         } catch (error) {
             console.error('[WhisperApp] Failed to kill job:', error);
+            return false;
         }
     }
 
@@ -682,58 +854,16 @@ class WhisperApp {
             });
     }
 
-    async exportAs(format) {
-        if (!this.transcriptionResult) return;
-
-        try {
-            let content = '';
-            let mimeType = 'text/plain';
-            const filename = `transcript-${Date.now()}.${format}`;
-
-            if (format === 'txt') {
-                content = this.transcriptionResult.text;
-                mimeType = 'text/plain';
-            } else if (format === 'srt') {
-                content = this.generateSRT();
-                mimeType = 'text/plain';
-            } else if (format === 'json') {
-                content = JSON.stringify(this.transcriptionResult, null, 2);
-                mimeType = 'application/json';
-            }
-
-            // Create and download file
-            const blob = new Blob([content], { type: mimeType });
-            const url = URL.createObjectURL(blob);
-            const a = document.createElement('a');
-            a.href = url;
-            a.download = filename;
-            document.body.appendChild(a);
-            a.click();
-            document.body.removeChild(a);
-            URL.revokeObjectURL(url);
-
-        } catch (error) {
-            console.error('Export failed:', error);
-            alert(`Export failed: ${error.message}`);
-        }
-    }
-
-    generateSRT() {
-        let srt = '';
-        this.transcriptionResult.segments.forEach((segment, index) => {
-            const start = this.formatSRTTime(segment.start);
-            const end = this.formatSRTTime(segment.end);
-            srt += `${index + 1}\n${start} --> ${end}\n${segment.text.trim()}\n\n`;
-        });
-        return srt;
-    }
+    exportAs(format) {
+        if (!this.transcriptionResult || !this.selectedJobId) return;
 
-    formatSRTTime(seconds) {
-        const hours = Math.floor(seconds / 3600);
-        const minutes = Math.floor((seconds % 3600) / 60);
-        const secs = Math.floor(seconds % 60);
-        const ms = Math.floor((seconds % 1) * 1000);
-        return `${String(hours).padStart(2, '0')}:${String(minutes).padStart(2, '0')}:${String(secs).padStart(2, '0')},${String(ms).padStart(3, '0')}`;
+        // The server renders every format and names the file after the upload
+        const a = document.createElement('a');
+        a.href = `${this.serverUrl}/jobs/${encodeURIComponent(this.selectedJobId)}/export?format=${format}`;
+        a.download = '';
+        document.body.appendChild(a);
+        a.click();
+        document.body.removeChild(a);
     }
 
     async clearCompleted() {
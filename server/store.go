@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// compactSlack is how many records beyond the live job count the journal may
+// accumulate before it is rewritten.
+const compactSlack = 256
+
+// JobStore persists jobs in an append-only JSON-lines journal so the queue
+// and job history survive a server restart. Every state change appends a full
+// snapshot of the job; the latest record for an ID wins on replay.
+type JobStore struct {
+	path    string
+	file    *os.File
+	mu      sync.Mutex
+	live    map[string]struct{} // IDs with a live (non-deleted) record
+	records int                 // Records in the journal file
+}
+
+type journalRecord struct {
+	Op  string `json:"op"` // "put" or "delete"
+	ID  string `json:"id"`
+	Job *Job   `json:"job,omitempty"`
+}
+
+// OpenJobStore replays the journal at path, compacts it and returns the store
+// together with the restored jobs ordered by creation time.
+func OpenJobStore(path string) (*JobStore, []*Job, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	jobs, err := replayJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := &JobStore{path: path}
+	if err := s.rewrite(jobs); err != nil {
+		return nil, nil, err
+	}
+
+	restored := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		restored = append(restored, job)
+	}
+	sort.SliceStable(restored, func(i, j int) bool {
+		return restored[i].CreatedAt.Before(restored[j].CreatedAt)
+	})
+
+	return s, restored, nil
+}
+
+// replayJournal reads every record in the journal and returns the latest
+// snapshot of each job that has not been deleted.
+func replayJournal(path string) (map[string]*Job, error) {
+	jobs := make(map[string]*Job)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return jobs, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open job journal: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec journalRecord
+			if jsonErr := json.Unmarshal(line, &rec); jsonErr != nil {
+				// A torn write from a crash can only affect the tail, so skip it
+				log.Printf("[Store] Ignoring unreadable journal record at line %d: %v", lineNo, jsonErr)
+			} else {
+				switch rec.Op {
+				case "put":
+					if rec.Job != nil {
+						jobs[rec.ID] = rec.Job
+					}
+				case "delete":
+					delete(jobs, rec.ID)
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job journal: %w", err)
+		}
+	}
+
+	return jobs, nil
+}
+
+// rewrite atomically replaces the journal with one record per job and reopens
+// it for appending.
+func (s *JobStore) rewrite(jobs map[string]*Job) error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create job journal: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	live := make(map[string]struct{}, len(jobs))
+	for id, job := range jobs {
+		data, err := json.Marshal(journalRecord{Op: "put", ID: id, Job: job})
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to encode job %s: %w", id, err)
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+		live[id] = struct{}{}
+	}
+
+	if err := w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write job journal: %w", err)
+	}
+	tmp.Close()
+
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace job journal: %w", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open job journal: %w", err)
+	}
+
+	s.file = file
+	s.live = live
+	s.records = len(jobs)
+	return nil
+}
+
+// Put appends a snapshot of job to the journal.
+func (s *JobStore) Put(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(journalRecord{Op: "put", ID: job.ID, Job: job}); err != nil {
+		return err
+	}
+	s.live[job.ID] = struct{}{}
+	return s.maybeCompact()
+}
+
+// Delete records that the job with the given ID was removed.
+func (s *JobStore) Delete(jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.live[jobID]; !ok {
+		return nil
+	}
+	if err := s.append(journalRecord{Op: "delete", ID: jobID}); err != nil {
+		return err
+	}
+	delete(s.live, jobID)
+	return s.maybeCompact()
+}
+
+func (s *JobStore) append(rec journalRecord) error {
+	if s.file == nil {
+		return fmt.Errorf("job journal is closed")
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode job %s: %w", rec.ID, err)
+	}
+	data = append(data, '\n')
+
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to append to job journal: %w", err)
+	}
+	s.records++
+	return s.file.Sync()
+}
+
+func (s *JobStore) maybeCompact() error {
+	if s.records <= 2*len(s.live)+compactSlack {
+		return nil
+	}
+
+	jobs, err := replayJournal(s.path)
+	if err != nil {
+		return err
+	}
+	return s.rewrite(jobs)
+}
+
+// Close flushes and closes the journal.
+func (s *JobStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// subtitlePauseBreak is the silence, in seconds, after which a new cue is
+// always started
+const subtitlePauseBreak = 1.5
+
+// SubtitleStyle constrains how a transcript is split into subtitle cues.
+// Zero fields are unconstrained.
+type SubtitleStyle struct {
+	MaxLineLength int     `json:"max_line_length"` // Characters per line
+	MaxLines      int     `json:"max_lines"`       // Lines per cue
+	MinDuration   float64 `json:"min_duration"`    // Seconds a cue stays on screen at least
+	MaxDuration   float64 `json:"max_duration"`    // Seconds a cue stays on screen at most
+	MaxCPS        float64 `json:"max_cps"`         // Reading speed in characters per second, spaces included
+	MinGap        float64 `json:"min_gap"`         // Seconds between the end of a cue and the start of the next
+}
+
+// subtitlePresets are the styles selectable with preset=<name>
+var subtitlePresets = map[string]SubtitleStyle{
+	// Netflix timed text style guide for English: 20 frames minimum, 2 frames
+	// apart at 24 fps
+	"netflix": {MaxLineLength: 42, MaxLines: 2, MinDuration: 0.833, MaxDuration: 7, MaxCPS: 20, MinGap: 0.083},
+	// Short, fast cues that sit well on top of a video player's controls
+	"youtube": {MaxLineLength: 42, MaxLines: 2, MinDuration: 1, MaxDuration: 6, MaxCPS: 25, MinGap: 0},
+	// Captioning guidelines for deaf and hard-of-hearing viewers: shorter
+	// lines and slower reading speed
+	"accessibility": {MaxLineLength: 32, MaxLines: 2, MinDuration: 1.5, MaxDuration: 6, MaxCPS: 15, MinGap: 0.125},
+}
+
+// subtitlePresetNames lists the presets in the order they are documented
+var subtitlePresetNames = []string{"netflix", "youtube", "accessibility"}
+
+// subtitleCue is one subtitle as shown on screen
+type subtitleCue struct {
+	Start, End float64
+	Text       string // Lines separated by "\n"
+	Speaker    string
+	Words      []TranscriptionWord // The cue's words if the transcript has word timings
+}
+
+// cueWords is a cue's words before layout, with the time it is shown from.
+// That is its first word's start, or later if it waits for the previous cue
+// to be read.
+type cueWords struct {
+	words []timedWord
+	start float64
+}
+
+// timedWord is a word on its way into a cue. Words of segments without word
+// timings get times interpolated from the segment's.
+type timedWord struct {
+	TranscriptionWord
+	Speaker string
+	Timed   bool // Whether the times came from the decoder
+}
+
+// parseSubtitleStyle reads the preset and the max_lines, min_duration,
+// max_duration, max_cps and min_gap query parameters. max_line_length also
+// applies once any of them is given. It returns nil if none is, which keeps
+// one cue per segment.
+func parseSubtitleStyle(query url.Values) (*SubtitleStyle, error) {
+	var style SubtitleStyle
+	enabled := false
+
+	if name := strings.ToLower(query.Get("preset")); name != "" {
+		preset, ok := subtitlePresets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown subtitle preset %q (supported: %s)", name, strings.Join(subtitlePresetNames, ", "))
+		}
+		style = preset
+		enabled = true
+	}
+
+	if value := query.Get("max_lines"); value != "" {
+		lines, err := strconv.Atoi(value)
+		if err != nil || lines < 1 {
+			return nil, fmt.Errorf("invalid max_lines %q (a positive number)", value)
+		}
+		style.MaxLines = lines
+		enabled = true
+	}
+	for _, param := range []struct {
+		name  string
+		value *float64
+	}{
+		{"min_duration", &style.MinDuration},
+		{"max_duration", &style.MaxDuration},
+		{"max_cps", &style.MaxCPS},
+		{"min_gap", &style.MinGap},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || number < 0 || math.IsInf(number, 0) {
+			return nil, fmt.Errorf("invalid %s %q (a number of seconds or characters, 0 for no limit)", param.name, value)
+		}
+		*param.value = number
+		enabled = true
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+	if value := query.Get("max_line_length"); value != "" {
+		// Already validated with the other export options
+		style.MaxLineLength, _ = strconv.Atoi(value)
+	}
+	if style.MaxDuration > 0 && style.MinDuration > style.MaxDuration {
+		return nil, fmt.Errorf("min_duration %.3g is longer than max_duration %.3g", style.MinDuration, style.MaxDuration)
+	}
+	return &style, nil
+}
+
+// subtitleCues lays out the cues of an SRT or WebVTT export: reflowed if
+// opts has a subtitle style, otherwise one per segment
+func subtitleCues(segments []TranscriptionSegment, opts ExportOptions) []subtitleCue {
+	if opts.Subtitles != nil {
+		return reflowSubtitles(segments, *opts.Subtitles)
+	}
+
+	cues := make([]subtitleCue, len(segments))
+	for i, segment := range segments {
+		text := segment.Text
+		if opts.MaxLineLength > 0 {
+			text = wrapText(strings.TrimSpace(text), opts.MaxLineLength)
+		}
+		cues[i] = subtitleCue{Start: segment.Start, End: segment.End, Text: text, Speaker: segment.Speaker, Words: segment.Words}
+	}
+	return cues
+}
+
+// reflowSubtitles re-splits and merges segments into cues that satisfy style.
+// Cues break at speaker changes and pauses, and otherwise hold as many words
+// as fit, preferring to end at a sentence or clause. Cues that couldn't be
+// read in time are then merged or re-split with the next one, and display
+// times are stretched for the minimum duration and reading speed without
+// running into the next cue.
+func reflowSubtitles(segments []TranscriptionSegment, style SubtitleStyle) []subtitleCue {
+	var groups [][]timedWord
+	var cur []timedWord
+
+	for _, word := range splitWords(segments) {
+		if len(cur) == 0 {
+			cur = append(cur, word)
+			continue
+		}
+
+		prev := cur[len(cur)-1]
+		switch {
+		case word.Speaker != prev.Speaker || word.Start-prev.End >= subtitlePauseBreak:
+			groups = append(groups, cur)
+			cur = nil
+		case style.overflows(cur, word):
+			// End the cue at its last clause boundary and carry the rest over
+			split := clauseBreak(cur)
+			groups = append(groups, cur[:split])
+			cur = append([]timedWord(nil), cur[split:]...)
+			if len(cur) > 0 && style.overflows(cur, word) {
+				groups = append(groups, cur)
+				cur = nil
+			}
+		case endsSentence(prev.Text) && style.fill(cur) >= 0.5:
+			// Start the next sentence on a fresh cue once this one is well filled
+			groups = append(groups, cur)
+			cur = nil
+		}
+		cur = append(cur, word)
+	}
+	if len(cur) > 0 {
+		groups = append(groups, cur)
+	}
+
+	cues := make([]subtitleCue, 0, len(groups))
+	for _, group := range style.fitReadingSpeed(groups) {
+		cue := style.cue(group.words)
+		cue.Start = group.start
+		cues = append(cues, cue)
+	}
+	style.retime(cues)
+	return cues
+}
+
+// splitWords flattens segments into words, interpolating times by length
+// for segments without word timings
+func splitWords(segments []TranscriptionSegment) []timedWord {
+	var words []timedWord
+	for _, segment := range segments {
+		if len(segment.Words) > 0 {
+			for _, word := range segment.Words {
+				words = append(words, timedWord{TranscriptionWord: word, Speaker: segment.Speaker, Timed: true})
+			}
+			continue
+		}
+
+		fields := strings.Fields(segment.Text)
+		total := 0
+		for _, field := range fields {
+			total += utf8.RuneCountInString(field) + 1
+		}
+		at := segment.Start
+		perChar := (segment.End - segment.Start) / math.Max(float64(total), 1)
+		for _, field := range fields {
+			end := at + float64(utf8.RuneCountInString(field)+1)*perChar
+			words = append(words, timedWord{
+				TranscriptionWord: TranscriptionWord{Text: field, Start: at, End: math.Min(end, segment.End)},
+				Speaker:           segment.Speaker,
+			})
+			at = end
+		}
+	}
+	return words
+}
+
+// overflows reports whether adding word to cur would break the line or
+// duration limits
+func (s SubtitleStyle) overflows(cur []timedWord, word timedWord) bool {
+	return s.tooLong(append(cur[:len(cur):len(cur)], word))
+}
+
+// tooLong reports whether words break the line or duration limits as one cue
+func (s SubtitleStyle) tooLong(words []timedWord) bool {
+	if s.MaxDuration > 0 && words[len(words)-1].End-words[0].Start > s.MaxDuration {
+		return true
+	}
+	if s.MaxLineLength <= 0 || s.MaxLines <= 0 {
+		return false
+	}
+	return strings.Count(wrapText(joinWords(words), s.MaxLineLength), "\n")+1 > s.MaxLines
+}
+
+// fill returns how much of the cue's character room words take up, from 0
+// to 1, or 0 if the room is unlimited
+func (s SubtitleStyle) fill(words []timedWord) float64 {
+	if s.MaxLineLength <= 0 || s.MaxLines <= 0 {
+		return 0
+	}
+	return float64(utf8.RuneCountInString(joinWords(words))) / float64(s.MaxLineLength*s.MaxLines)
+}
+
+// cue builds a cue from words, balancing its lines
+func (s SubtitleStyle) cue(words []timedWord) subtitleCue {
+	cue := subtitleCue{
+		Start:   words[0].Start,
+		End:     words[len(words)-1].End,
+		Text:    balanceLines(joinWords(words), s.MaxLineLength, s.MaxLines),
+		Speaker: words[0].Speaker,
+	}
+	for _, word := range words {
+		if !word.Timed {
+			cue.Words = nil
+			break
+		}
+		cue.Words = append(cue.Words, word.TranscriptionWord)
+	}
+	return cue
+}
+
+// fitReadingSpeed makes room to read every cue at MaxCPS. Where a cue can't
+// stay up long enough before the next one starts, it is merged with the next
+// cue if the two fit on screen together. Otherwise the words are re-split
+// between the two, and the second is shown once the first has been read, as
+// long as that is before its speech ends. Words only move between cues of
+// one speaker without a pause between them; across a speaker change or pause
+// the next cue can only be delayed. Speech faster than MaxCPS over both cues
+// is left as it is.
+func (s SubtitleStyle) fitReadingSpeed(groups [][]timedWord) []cueWords {
+	cues := make([]cueWords, len(groups))
+	for i, words := range groups {
+		cues[i] = cueWords{words: words, start: words[0].Start}
+	}
+	if s.MaxCPS <= 0 {
+		return cues
+	}
+
+	for i := 0; i+1 < len(cues); {
+		cur, next := cues[i], cues[i+1]
+		if s.readable(cur, next.start) {
+			i++
+			continue
+		}
+		after := math.Inf(1)
+		if i+2 < len(cues) {
+			after = cues[i+2].start
+		}
+
+		last := cur.words[len(cur.words)-1]
+		movable := last.Speaker == next.words[0].Speaker && next.words[0].Start-last.End < subtitlePauseBreak
+		words := append(append([]timedWord(nil), cur.words...), next.words...)
+		merged := cueWords{words: words, start: cur.start}
+		if movable && !s.tooLong(words) && s.readable(merged, after) {
+			cues[i] = merged
+			cues = append(cues[:i+1], cues[i+2:]...)
+			continue // The merged cue may need the one after, too
+		}
+
+		from, to := len(cur.words), len(cur.words)
+		if movable {
+			from, to = 1, len(words)-1
+		}
+		if a, b, ok := s.resplit(words, cur.start, after, from, to); ok {
+			cues[i], cues[i+1] = a, b
+		}
+		i++
+	}
+	return cues
+}
+
+// resplit splits words in two after the k-th word, for k from first to last,
+// such that both cues can be read in time: the first shown from start, the
+// second from when the first has been read, before its own speech ends and
+// before after. Splits at the end of a clause are preferred, then those that
+// delay the second cue least.
+func (s SubtitleStyle) resplit(words []timedWord, start, after float64, first, last int) (cueWords, cueWords, bool) {
+	var best [2]cueWords
+	found, bestClause, bestDelay := false, false, 0.0
+	for k := first; k <= last; k++ {
+		a := cueWords{words: words[:k], start: start}
+		b := cueWords{words: words[k:], start: math.Max(words[k].Start, start+s.readingTime(words[:k])+s.MinGap)}
+		if b.start >= words[len(words)-1].End || s.tooLong(a.words) || s.tooLong(b.words) {
+			continue
+		}
+		if !s.readable(a, b.start) || !s.readable(b, after) {
+			continue
+		}
+
+		clause, delay := endsClause(words[k-1].Text), b.start-words[k].Start
+		if !found || (clause && !bestClause) || (clause == bestClause && delay < bestDelay) {
+			best, found, bestClause, bestDelay = [2]cueWords{a, b}, true, clause, delay
+		}
+	}
+	return best[0], best[1], found
+}
+
+// readable reports whether c can stay on screen long enough to be read at
+// MaxCPS before next starts, within the maximum duration
+func (s SubtitleStyle) readable(c cueWords, next float64) bool {
+	end := next - s.MinGap
+	if s.MaxDuration > 0 {
+		end = math.Min(end, math.Max(c.words[len(c.words)-1].End, c.start+s.MaxDuration))
+	}
+	// Allow for rounding in the sums above
+	return c.start+s.readingTime(c.words) <= end+1e-9
+}
+
+// readingTime returns how long words take to read at MaxCPS
+func (s SubtitleStyle) readingTime(words []timedWord) float64 {
+	return float64(utf8.RuneCountInString(joinWords(words))) / s.MaxCPS
+}
+
+// retime stretches each cue to the minimum duration and the time needed to
+// read it, within the maximum duration and never closer than the minimum gap
+// to the next cue
+func (s SubtitleStyle) retime(cues []subtitleCue) {
+	for i := range cues {
+		cue := &cues[i]
+		end := cue.End
+		if s.MinDuration > 0 {
+			end = math.Max(end, cue.Start+s.MinDuration)
+		}
+		if s.MaxCPS > 0 {
+			chars := utf8.RuneCountInString(strings.ReplaceAll(cue.Text, "\n", " "))
+			end = math.Max(end, cue.Start+float64(chars)/s.MaxCPS)
+		}
+		if s.MaxDuration > 0 {
+			end = math.Min(end, math.Max(cue.End, cue.Start+s.MaxDuration))
+		}
+		if i+1 < len(cues) {
+			// Keep the gap to the next cue, unless the speech overlaps it
+			// so much that nothing of this cue would be left
+			if limit := cues[i+1].Start - s.MinGap; end > limit {
+				if limit > cue.Start {
+					end = limit
+				} else {
+					end = math.Min(end, cue.End)
+				}
+			}
+		}
+		cue.End = end
+	}
+}
+
+// clauseBreak returns where to split words so the first part ends a sentence
+// or clause, keeping at least half of the words in it. It returns len(words)
+// if there is no such place.
+func clauseBreak(words []timedWord) int {
+	for i := len(words); i >= (len(words)+1)/2; i-- {
+		if endsClause(words[i-1].Text) {
+			return i
+		}
+	}
+	return len(words)
+}
+
+// balanceLines wraps text into at most maxLines lines of at most width
+// characters, with the lines as even in length as possible. Text that doesn't
+// fit is wrapped at width.
+func balanceLines(text string, width, maxLines int) string {
+	if width <= 0 {
+		return text
+	}
+	wrapped := wrapText(text, width)
+	lines := strings.Count(wrapped, "\n") + 1
+	if lines == 1 || (maxLines > 0 && lines > maxLines) {
+		return wrapped
+	}
+
+	// The narrowest width that needs no more lines gives the most even ones
+	length := utf8.RuneCountInString(text)
+	for narrower := (length + lines - 1) / lines; narrower < width; narrower++ {
+		if candidate := wrapText(text, narrower); strings.Count(candidate, "\n")+1 <= lines {
+			return candidate
+		}
+	}
+	return wrapped
+}
+
+func joinWords(words []timedWord) string {
+	texts := make([]string, len(words))
+	for i, word := range words {
+		texts[i] = word.Text
+	}
+	return strings.Join(texts, " ")
+}
+
+// endsSentence reports whether a word ends a sentence
+func endsSentence(word string) bool {
+	word = strings.TrimRight(word, `"')]”’`)
+	return strings.HasSuffix(word, ".") || strings.HasSuffix(word, "?") || strings.HasSuffix(word, "!") || strings.HasSuffix(word, "…")
+}
+
+// endsClause reports whether a word ends a sentence or clause
+func endsClause(word string) bool {
+	return endsSentence(word) || strings.HasSuffix(word, ",") || strings.HasSuffix(word, ";") || strings.HasSuffix(word, ":")
+}
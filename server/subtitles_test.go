@@ -0,0 +1,243 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// segment builds a transcript segment without word timings
+func segment(start, end float64, speaker, text string) TranscriptionSegment {
+	return TranscriptionSegment{Start: start, End: end, Text: " " + text, Speaker: speaker}
+}
+
+// cueText returns a cue's text on one line
+func cueText(cue subtitleCue) string {
+	return strings.ReplaceAll(cue.Text, "\n", " ")
+}
+
+// checkWords fails unless cues hold exactly the words of segments, in order
+func checkWords(t *testing.T, segments []TranscriptionSegment, cues []subtitleCue) {
+	t.Helper()
+	var want, got []string
+	for _, segment := range segments {
+		want = append(want, strings.Fields(segment.Text)...)
+	}
+	for _, cue := range cues {
+		got = append(got, strings.Fields(cue.Text)...)
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("cues hold\n%q\nwant\n%q", strings.Join(got, " "), strings.Join(want, " "))
+	}
+}
+
+// checkLimits fails for every cue that breaks one of style's limits
+func checkLimits(t *testing.T, style SubtitleStyle, cues []subtitleCue) {
+	t.Helper()
+	const epsilon = 1e-6
+	for i, cue := range cues {
+		lines := strings.Split(cue.Text, "\n")
+		if style.MaxLines > 0 && len(lines) > style.MaxLines {
+			t.Errorf("cue %d has %d lines, max %d: %q", i, len(lines), style.MaxLines, cue.Text)
+		}
+		for _, line := range lines {
+			if n := utf8.RuneCountInString(line); style.MaxLineLength > 0 && n > style.MaxLineLength && strings.Contains(line, " ") {
+				t.Errorf("cue %d has a line of %d characters, max %d: %q", i, n, style.MaxLineLength, line)
+			}
+		}
+
+		duration := cue.End - cue.Start
+		if duration <= 0 {
+			t.Errorf("cue %d ends at %.3f, before it starts at %.3f", i, cue.End, cue.Start)
+		}
+		if style.MaxDuration > 0 && duration > style.MaxDuration+epsilon {
+			t.Errorf("cue %d lasts %.3fs, max %.3fs", i, duration, style.MaxDuration)
+		}
+		if style.MaxCPS > 0 {
+			if cps := float64(utf8.RuneCountInString(cueText(cue))) / duration; cps > style.MaxCPS+epsilon {
+				t.Errorf("cue %d is %.1f characters per second, max %.1f: %q", i, cps, style.MaxCPS, cueText(cue))
+			}
+		}
+		if i+1 < len(cues) {
+			next := cues[i+1]
+			if gap := next.Start - cue.End; gap < style.MinGap-epsilon {
+				t.Errorf("cue %d is %.3fs before the next, min %.3fs", i, gap, style.MinGap)
+			}
+			if style.MinDuration > 0 && duration < style.MinDuration-epsilon && next.Start-style.MinGap > cue.Start+style.MinDuration {
+				t.Errorf("cue %d lasts %.3fs, min %.3fs, though there is room", i, duration, style.MinDuration)
+			}
+		}
+	}
+}
+
+// talk is a few minutes of speech at about 13 characters per second, with
+// pauses between the paragraphs
+func talk() []TranscriptionSegment {
+	paragraphs := []string{
+		"Thanks for joining us today. We have a lot to get through, so let's start with the numbers from last quarter, which were better than any of us expected when we planned the year.",
+		"Revenue grew by twelve percent, mostly thanks to the new subscription plans, and churn fell for the third quarter in a row.",
+		"The question everyone keeps asking is whether this will last. Honestly, we don't know yet, but the early signs from this quarter are encouraging, and the team is confident.",
+		"Okay.",
+		"Let's move on to hiring, where the picture is more mixed: we filled most of the engineering roles, but sales is still short of people in two regions.",
+	}
+
+	var segments []TranscriptionSegment
+	at := 0.0
+	for _, text := range paragraphs {
+		duration := float64(utf8.RuneCountInString(text)) / 13
+		segments = append(segments, segment(at, at+duration, "", text))
+		at += duration + 2
+	}
+	return segments
+}
+
+func TestReflowPresets(t *testing.T) {
+	for _, name := range subtitlePresetNames {
+		t.Run(name, func(t *testing.T) {
+			style := subtitlePresets[name]
+			segments := talk()
+			cues := reflowSubtitles(segments, style)
+			checkWords(t, segments, cues)
+			checkLimits(t, style, cues)
+		})
+	}
+}
+
+func TestReflowLongSegment(t *testing.T) {
+	text := strings.Repeat("and then we walked a little further down the road ", 12)
+	segments := []TranscriptionSegment{segment(10, 60, "", strings.TrimSpace(text))}
+	style := subtitlePresets["netflix"]
+
+	cues := reflowSubtitles(segments, style)
+	if room := style.MaxLineLength * style.MaxLines; len(cues) < len(text)/room {
+		t.Errorf("got %d cues for %d characters, want at least %d", len(cues), len(text), len(text)/room)
+	}
+	checkWords(t, segments, cues)
+	checkLimits(t, style, cues)
+	if cues[0].Start != 10 {
+		t.Errorf("first cue starts at %.3f, want 10", cues[0].Start)
+	}
+}
+
+func TestReflowOverlongWord(t *testing.T) {
+	word := strings.Repeat("x", 60)
+	segments := []TranscriptionSegment{segment(0, 6, "", "before "+word+" after")}
+	style := SubtitleStyle{MaxLineLength: 20, MaxLines: 1}
+
+	cues := reflowSubtitles(segments, style)
+	checkWords(t, segments, cues)
+	found := false
+	for _, cue := range cues {
+		if strings.Contains(cue.Text, word) {
+			found = true
+			if cue.Text != word {
+				t.Errorf("overlong word shares its cue: %q", cue.Text)
+			}
+		}
+	}
+	if !found {
+		t.Error("overlong word is missing")
+	}
+}
+
+func TestReflowBreaksAtPauses(t *testing.T) {
+	style := subtitlePresets["youtube"]
+
+	segments := []TranscriptionSegment{
+		segment(0, 1, "", "so we went"),
+		segment(1+subtitlePauseBreak, 3+subtitlePauseBreak, "", "to the beach"),
+	}
+	if cues := reflowSubtitles(segments, style); len(cues) != 2 {
+		t.Errorf("got %d cues across a pause, want 2", len(cues))
+	}
+
+	segments[1] = segment(1.2, 3, "", "to the beach")
+	if cues := reflowSubtitles(segments, style); len(cues) != 1 {
+		t.Errorf("got %d cues without a pause, want 1", len(cues))
+	}
+}
+
+func TestReflowBreaksAtSpeakers(t *testing.T) {
+	segments := []TranscriptionSegment{
+		segment(0, 1, "Agent", "how can I help"),
+		segment(1.1, 2, "Customer", "my order is late"),
+	}
+
+	cues := reflowSubtitles(segments, subtitlePresets["netflix"])
+	if len(cues) != 2 {
+		t.Fatalf("got %d cues, want one per speaker", len(cues))
+	}
+	if cues[0].Speaker != "Agent" || cues[1].Speaker != "Customer" {
+		t.Errorf("speakers are %q and %q", cues[0].Speaker, cues[1].Speaker)
+	}
+}
+
+func TestReflowMergesCuesTooFastToRead(t *testing.T) {
+	// A fast sentence, then a short reply and a long silence: the sentence
+	// can only be read in time by showing the reply with it
+	segments := []TranscriptionSegment{
+		segment(0, 2, "", "This is a fairly long sentence spoken really fast."),
+		segment(2.1, 2.4, "", "Right."),
+		segment(20, 22, "", "Much later."),
+	}
+	style := subtitlePresets["netflix"]
+
+	cues := reflowSubtitles(segments, style)
+	checkWords(t, segments, cues)
+	checkLimits(t, style, cues)
+	if len(cues) != 2 {
+		t.Errorf("got %d cues, want the reply merged into the sentence's", len(cues))
+	}
+}
+
+func TestReflowResplitsCuesTooFastToRead(t *testing.T) {
+	// Too much text to show at once, so the words are shared out between
+	// the cues and the second waits until the first has been read
+	segments := []TranscriptionSegment{
+		segment(0, 2.5, "", "This sentence is long enough to fill most of a cue, and it is spoken fast."),
+		segment(2.6, 5, "", "Then there is another one that takes a while."),
+		segment(30, 32, "", "Much later."),
+	}
+	style := subtitlePresets["netflix"]
+
+	cues := reflowSubtitles(segments, style)
+	checkWords(t, segments, cues)
+	checkLimits(t, style, cues)
+}
+
+func TestReflowDelaysNextSpeaker(t *testing.T) {
+	segments := []TranscriptionSegment{
+		segment(0, 2, "Agent", "This is a fairly long sentence spoken really fast."),
+		segment(2.1, 6, "Customer", "Okay, then let's go."),
+	}
+	style := subtitlePresets["netflix"]
+
+	cues := reflowSubtitles(segments, style)
+	checkWords(t, segments, cues)
+	checkLimits(t, style, cues)
+	if len(cues) != 2 || cues[0].Speaker != "Agent" || cues[1].Speaker != "Customer" {
+		t.Fatalf("cues = %+v, want one per speaker", cues)
+	}
+	if cues[1].Start <= 2.1 {
+		t.Errorf("second speaker shown at %.3f, want it delayed until the first cue is read", cues[1].Start)
+	}
+}
+
+func TestReflowTooFastSpeechKeepsWords(t *testing.T) {
+	// Nothing can make this readable at 15 characters per second; the cues
+	// must still hold every word and stay in order
+	var segments []TranscriptionSegment
+	for i := 0; i < 6; i++ {
+		start := float64(i)
+		segments = append(segments, segment(start, start+0.95, "", "words words words words words words"))
+	}
+
+	cues := reflowSubtitles(segments, subtitlePresets["accessibility"])
+	checkWords(t, segments, cues)
+	for i := 1; i < len(cues); i++ {
+		if cues[i].Start < cues[i-1].End || math.IsNaN(cues[i].Start) {
+			t.Errorf("cue %d starts at %.3f, before cue %d ends at %.3f", i, cues[i].Start, i-1, cues[i-1].End)
+		}
+	}
+}
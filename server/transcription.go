@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -15,138 +14,417 @@ import (
 	"sync"
 	"time"
 
-	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	"github.com/hnrqer/transcriber-pro/protocol"
 )
 
 type JobStatus string
 
 const (
-	StatusQueued      JobStatus = "queued"
-	StatusProcessing  JobStatus = "processing"
+	StatusQueued       JobStatus = "queued"
+	StatusProcessing   JobStatus = "processing"
 	StatusTranscribing JobStatus = "transcribing"
-	StatusCompleted   JobStatus = "completed"
-	StatusFailed      JobStatus = "failed"
+	StatusCompleted    JobStatus = "completed"
+	StatusFailed       JobStatus = "failed"
 )
 
+// Job lifecycle events reported to the engine's listeners
+const (
+	JobEventQueued    = "queued"
+	JobEventStarted   = "started"
+	JobEventCompleted = "completed"
+	JobEventFailed    = "failed"
+	JobEventCancelled = "cancelled"
+	JobEventKilled    = "killed"
+)
+
+// Tasks a job can run, chosen with task= at upload. taskBoth queues one job
+// of each for the same upload.
+const (
+	taskTranscribe = "transcribe"
+	taskTranslate  = "translate"
+	taskBoth       = "both"
+)
+
+// Job changes between lifecycle transitions, also reported to the listeners
+const (
+	JobEventUpdated = "updated" // Progress, message, ETA or queue position changed
+	JobEventSegment = "segment" // The worker decoded a segment
+	JobEventRemoved = "removed" // The job was cleared
+)
+
+// JobEvent is a change to a job
+type JobEvent struct {
+	Type    string
+	Job     Job                   // Snapshot of the job after the change, without its partial result
+	Segment *TranscriptionSegment // The new segment for JobEventSegment
+	Time    time.Time
+	Seq     uint64 // Increases by one with every event
+}
+
+// IsLifecycle reports whether the event is a lifecycle transition rather
+// than an intermediate update
+func (ev JobEvent) IsLifecycle() bool {
+	switch ev.Type {
+	case JobEventUpdated, JobEventSegment, JobEventRemoved:
+		return false
+	}
+	return true
+}
+
 type Job struct {
-	ID           string
-	Status       JobStatus
-	Progress     float64
-	Message      string
-	ETA          string // Estimated time remaining
-	Result       *TranscriptionResult
-	Error        string
-	FileName     string // Original filename for display
+	ID            string
+	Status        JobStatus
+	Progress      float64
+	Message       string
+	ETA           string // Estimated time remaining
+	Result        *TranscriptionResult
+	Partial       *TranscriptionResult `json:"-"` // Segments decoded so far while transcribing
+	Error         string
+	FileName      string // Original filename for display
 	QueuePosition int    // Position in queue (0 if not queued)
-	AudioPath    string // Path to audio file
-	Language     string // Language for transcription
+	AudioPath     string // Path to audio file
+	CreatedAt     time.Time
+	JobOptions
+}
+
+// JobOptions are the per-job transcription settings chosen at upload time
+type JobOptions struct {
+	Language       string  // Language for transcription
+	Model          string  // Model name, empty for the server default
+	CallbackURL    string  `json:",omitempty"` // Webhook notified of this job's lifecycle events
+	Prompt         string  `json:",omitempty"` // Initial prompt that primes the decoder, including the vocabulary's terms
+	Vocabulary     string  `json:",omitempty"` // Name of the vocabulary the prompt was built with
+	TermsDropped   int     `json:",omitempty"` // Vocabulary terms left out to fit the prompt window
+	Temperature    float32 `json:",omitempty"` // Sampling temperature, 0 for the default
+	Translate      bool    `json:",omitempty"` // Translate the speech to English
+	WordTimestamps bool    `json:",omitempty"` // Add per-word timings to every segment
+
+	// SplitChannels transcribes each audio channel on its own and labels its
+	// segments with the channel's speaker: Speakers[i] for channel i+1, or
+	// "Channel N" if no name was given
+	SplitChannels bool     `json:",omitempty"`
+	Speakers      []string `json:",omitempty"`
+
+	// Diarize runs the server's diarize command on the audio, and
+	// SpeakerTurns are speakers imported from an uploaded RTTM file. Either
+	// labels the segments, splitting them where the speaker changes.
+	Diarize      bool          `json:",omitempty"`
+	SpeakerTurns []SpeakerTurn `json:",omitempty"`
+
+	// LinkedJobID is the job running the other task on the same upload, for
+	// uploads with task=both
+	LinkedJobID string `json:",omitempty"`
+}
+
+// Task returns the task the job runs: taskTranscribe or taskTranslate
+func (o JobOptions) Task() string {
+	if o.Translate {
+		return taskTranslate
+	}
+	return taskTranscribe
 }
 
 type TranscriptionResult struct {
-	Text     string              `json:"text"`
+	Text     string                 `json:"text"`
 	Segments []TranscriptionSegment `json:"segments"`
-	Language string              `json:"language"`
+	Language string                 `json:"language"`
+	Model    string                 `json:"model,omitempty"`
+	Duration float64                `json:"duration,omitempty"` // Audio length in seconds
+
+	// Task is "translate" if the text is an English translation, in which
+	// case Language is still the language spoken
+	Task        string `json:"task,omitempty"`
+	LinkedJobID string `json:"linked_job_id,omitempty"` // Job with the other task's result for the same upload
+
+	// The initial prompt the decoder was given, and the vocabulary it was
+	// built from, to reproduce a run. TermsDropped counts the vocabulary's
+	// last terms that didn't fit in the prompt.
+	Prompt       string `json:"prompt,omitempty"`
+	Vocabulary   string `json:"vocabulary,omitempty"`
+	TermsDropped int    `json:"vocabulary_terms_dropped,omitempty"`
 }
 
-type TranscriptionSegment struct {
-	Start float64 `json:"start"`
-	End   float64 `json:"end"`
-	Text  string  `json:"text"`
-}
+// TranscriptionSegment is shared with the worker through the protocol package
+type TranscriptionSegment = protocol.Segment
+
+// TranscriptionWord is a timed word of a segment
+type TranscriptionWord = protocol.Word
 
 type TranscriptionEngine struct {
-	model            whisper.Model
+	models           *ModelManager
 	jobs             map[string]*Job
 	jobsMutex        sync.RWMutex
-	modelPath        string
-	queue            []string           // Queue of job IDs waiting to be processed
+	queue            []string // Queue of job IDs waiting to be processed
 	queueMutex       sync.Mutex
-	isProcessing     bool               // Whether a job is currently being processed
-	processingCond   *sync.Cond         // Condition variable for queue processing
-	cancelledJobs    map[string]bool    // Track cancelled jobs
-	cancelledJobsMux sync.RWMutex       // Mutex for cancelledJobs map
-	workerCmd        *exec.Cmd          // Currently running worker process
-	workerMutex      sync.Mutex         // Mutex for worker command
+	activeJobs       map[string]bool     // Jobs currently being processed by a worker slot
+	workerCount      int                 // Number of concurrent worker processes
+	processingCond   *sync.Cond          // Condition variable for queue processing
+	cancelledJobs    map[string]bool     // Track cancelled jobs
+	cancelledJobsMux sync.RWMutex        // Mutex for cancelledJobs map
+	workers          *workerRegistry     // Running worker process per job ID
+	warmWorkers      map[int]*warmWorker // Long-lived worker process per slot
+	warmMutex        sync.Mutex          // Mutex for warmWorkers
+	limits           workerLimits        // When to recycle warm workers
+	store            *JobStore           // Durable job journal
+	config           *Config
+	headless         bool // Jobs live in memory only, results aren't saved to the output dir and audio is left in place
+	listeners        []func(JobEvent)
+	listenersMutex   sync.RWMutex
+	eventSeq         uint64 // Seq of the last event; guarded by jobsMutex
 }
 
-func NewTranscriptionEngine() (*TranscriptionEngine, error) {
-	homeDir, err := os.UserHomeDir()
+// NewTranscriptionEngine returns the engine behind the HTTP server. Jobs are
+// journaled so they survive restarts, results are saved to the output
+// directory, and uploaded audio is deleted once transcribed. Jobs restored
+// from the journal don't run until Start is called.
+func NewTranscriptionEngine(cfg *Config) (*TranscriptionEngine, error) {
+	return newTranscriptionEngine(cfg, false)
+}
+
+// NewHeadlessEngine returns an engine for one-shot command-line use. It has
+// no job journal, doesn't write to the output directory and never deletes the
+// audio files it is given.
+func NewHeadlessEngine(cfg *Config) (*TranscriptionEngine, error) {
+	engine, err := newTranscriptionEngine(cfg, true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+		return nil, err
 	}
+	engine.Start()
+	return engine, nil
+}
 
-	modelDir := filepath.Join(homeDir, ".cache", "whisper")
-	if err := os.MkdirAll(modelDir, 0755); err != nil {
+func newTranscriptionEngine(cfg *Config, headless bool) (*TranscriptionEngine, error) {
+	if err := os.MkdirAll(cfg.ModelDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create model directory: %w", err)
 	}
 
-	modelPath := filepath.Join(modelDir, "ggml-large-v3.bin")
-	expectedSize := int64(3094000000) // ~3GB (approximate size of large-v3 model)
-
-	// Check if model file exists and validate it
-	needsDownload := false
-	if stat, err := os.Stat(modelPath); os.IsNotExist(err) {
-		needsDownload = true
-	} else if stat.Size() < expectedSize-100000000 { // Allow 100MB tolerance for compression differences
-		log.Printf("Model file appears incomplete (size: %d bytes, expected: ~%d bytes). Removing and re-downloading...", stat.Size(), expectedSize)
-		os.Remove(modelPath)
-		needsDownload = true
+	models := NewModelManager(cfg)
+	if err := models.ensureDefault(); err != nil {
+		return nil, err
 	}
 
-	if needsDownload {
-		log.Printf("Downloading Whisper large-v3 model (~3GB)...")
-		if err := downloadModel(modelPath); err != nil {
-			// Clean up partial download on failure
-			os.Remove(modelPath)
-			return nil, fmt.Errorf("failed to download model: %w", err)
+	var store *JobStore
+	var restored []*Job
+	if !headless {
+		var err error
+		store, restored, err = OpenJobStore(filepath.Join(cfg.StateDir, "jobs.jsonl"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open job store: %w", err)
 		}
 	}
 
-	log.Printf("Loading Whisper model from %s", modelPath)
-	model, err := whisper.New(modelPath)
-	if err != nil {
-		// If loading fails, the file might be corrupted - remove it
-		log.Printf("Failed to load model (file may be corrupted), removing: %v", err)
-		os.Remove(modelPath)
-		return nil, fmt.Errorf("failed to load model: %w (corrupted file removed, please restart to re-download)", err)
-	}
-
 	engine := &TranscriptionEngine{
-		model:         model,
+		models:        models,
 		jobs:          make(map[string]*Job),
-		modelPath:     modelPath,
 		queue:         make([]string, 0),
+		activeJobs:    make(map[string]bool),
+		workerCount:   cfg.Workers,
 		cancelledJobs: make(map[string]bool),
+		workers:       newWorkerRegistry(filepath.Join(cfg.StateDir, "kill-audit.jsonl")),
+		warmWorkers:   make(map[int]*warmWorker),
+		limits:        newWorkerLimits(cfg),
+		store:         store,
+		config:        cfg,
+		headless:      headless,
 	}
 	engine.processingCond = sync.NewCond(&engine.queueMutex)
-
-	// Start queue processor
-	go engine.processQueue()
+	engine.restoreJobs(restored)
+	engine.updateQueuePositions()
 
 	return engine, nil
 }
 
-func downloadModel(modelPath string) error {
-	url := "https://huggingface.co/ggerganov/whisper.cpp/resolve/main/ggml-large-v3.bin"
+// Start starts one queue processor per worker slot. Listeners registered
+// with OnJobEvent before Start see every event of the restored jobs.
+func (e *TranscriptionEngine) Start() {
+	log.Printf("[Queue] Starting %d worker slot(s)", e.workerCount)
+	for slot := 1; slot <= e.workerCount; slot++ {
+		go e.processQueue(slot)
+	}
+}
+
+// threadsPerWorker returns the configured thread count, or splits the
+// available CPUs evenly between worker slots
+func (e *TranscriptionEngine) threadsPerWorker() int {
+	if e.config.Threads > 0 {
+		return e.config.Threads
+	}
+	threads := runtime.NumCPU() / e.workerCount
+	if threads < 1 {
+		threads = 1
+	}
+	return threads
+}
+
+// Config returns the configuration the engine was started with
+func (e *TranscriptionEngine) Config() *Config {
+	return e.config
+}
 
-	cmd := exec.Command("curl", "-L", "-o", modelPath, url, "--progress-bar")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// Models returns the engine's model manager
+func (e *TranscriptionEngine) Models() *ModelManager {
+	return e.models
+}
 
-	return cmd.Run()
+// OnJobEvent registers fn to be called on every job event. fn is called
+// with the engine's job lock held, so it must not block or call back into
+// the engine.
+func (e *TranscriptionEngine) OnJobEvent(fn func(JobEvent)) {
+	e.listenersMutex.Lock()
+	defer e.listenersMutex.Unlock()
+	e.listeners = append(e.listeners, fn)
 }
 
-func (e *TranscriptionEngine) CreateJob(jobID, fileName, audioPath, language string) {
+// emitLocked reports a change to job to the listeners. Callers must hold
+// jobsMutex.
+func (e *TranscriptionEngine) emitLocked(eventType string, job *Job) {
+	e.emitEventLocked(JobEvent{Type: eventType, Job: *job})
+}
+
+// emitEventLocked fills in the event time and sequence number and calls the
+// listeners. Callers must hold jobsMutex.
+func (e *TranscriptionEngine) emitEventLocked(event JobEvent) {
+	e.eventSeq++
+	event.Seq = e.eventSeq
+
+	e.listenersMutex.RLock()
+	defer e.listenersMutex.RUnlock()
+	if len(e.listeners) == 0 {
+		return
+	}
+
+	event.Time = time.Now()
+	event.Job.Partial = nil
+	for _, fn := range e.listeners {
+		fn(event)
+	}
+}
+
+// jobEventFor returns the lifecycle event for a job entering status, or ""
+// if the status change isn't one
+func jobEventFor(status JobStatus, errorMsg string) string {
+	switch status {
+	case StatusQueued:
+		return JobEventQueued
+	case StatusProcessing:
+		return JobEventStarted
+	case StatusCompleted:
+		return JobEventCompleted
+	case StatusFailed:
+		switch errorMsg {
+		case "Cancelled by user":
+			return JobEventCancelled
+		case "Killed by user":
+			return JobEventKilled
+		}
+		return JobEventFailed
+	}
+	return ""
+}
+
+// restoreJobs loads jobs from the journal into the engine. Queued jobs are
+// re-queued in their original order, and jobs that were mid-transcription when
+// the server stopped are re-queued if their audio is still on disk.
+func (e *TranscriptionEngine) restoreJobs(jobs []*Job) {
 	e.jobsMutex.Lock()
-	e.jobs[jobID] = &Job{
-		ID:        jobID,
-		Status:    StatusQueued,
-		Progress:  0,
-		Message:   "Waiting in queue...",
-		FileName:  fileName,
-		AudioPath: audioPath,
-		Language:  language,
+	defer e.jobsMutex.Unlock()
+
+	requeued := 0
+	for _, job := range jobs {
+		switch job.Status {
+		case StatusQueued, StatusProcessing, StatusTranscribing:
+			interrupted := job.Status != StatusQueued
+			if _, err := os.Stat(job.AudioPath); err != nil {
+				job.Status = StatusFailed
+				job.Progress = 0
+				job.ETA = ""
+				job.Message = "Interrupted"
+				job.Error = "Interrupted by server restart (audio file no longer available)"
+			} else {
+				job.Status = StatusQueued
+				job.Progress = 0
+				job.ETA = ""
+				job.Message = "Waiting in queue..."
+				if interrupted {
+					log.Printf("[Job %s] Re-queued after interrupted transcription", job.ID)
+				}
+				e.queue = append(e.queue, job.ID)
+				requeued++
+			}
+			e.saveJobLocked(job)
+		}
+		e.jobs[job.ID] = job
+	}
+
+	if len(jobs) > 0 {
+		log.Printf("[Store] Restored %d jobs (%d queued)", len(jobs), requeued)
+	}
+}
+
+// CleanupUploads removes files in dir that do not belong to a queued job,
+// such as uploads left behind by a crash.
+func (e *TranscriptionEngine) CleanupUploads(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
 	}
+
+	e.jobsMutex.RLock()
+	inUse := make(map[string]bool)
+	for _, job := range e.jobs {
+		if job.Status != StatusCompleted && job.Status != StatusFailed {
+			inUse[filepath.Clean(job.AudioPath)] = true
+		}
+	}
+	e.jobsMutex.RUnlock()
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || inUse[path] {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			log.Printf("[Store] Removed orphaned upload %s", path)
+		}
+	}
+}
+
+// saveJobLocked writes a snapshot of job to the store. Callers must hold
+// jobsMutex.
+func (e *TranscriptionEngine) saveJobLocked(job *Job) {
+	if e.store == nil {
+		return
+	}
+	if err := e.store.Put(job); err != nil {
+		log.Printf("[Job %s] Warning: Failed to persist job: %v", job.ID, err)
+	}
+}
+
+// deleteJobLocked removes a job from the store. Callers must hold jobsMutex.
+func (e *TranscriptionEngine) deleteJobLocked(jobID string) {
+	if e.store == nil {
+		return
+	}
+	if err := e.store.Delete(jobID); err != nil {
+		log.Printf("[Job %s] Warning: Failed to remove persisted job: %v", jobID, err)
+	}
+}
+
+func (e *TranscriptionEngine) CreateJob(jobID, fileName, audioPath string, opts JobOptions) {
+	e.jobsMutex.Lock()
+	job := &Job{
+		ID:         jobID,
+		Status:     StatusQueued,
+		Progress:   0,
+		Message:    "Waiting in queue...",
+		FileName:   fileName,
+		AudioPath:  audioPath,
+		CreatedAt:  time.Now(),
+		JobOptions: opts,
+	}
+	e.jobs[jobID] = job
+	e.saveJobLocked(job)
+	e.emitLocked(JobEventQueued, job)
 	e.jobsMutex.Unlock()
 
 	// Add to queue
@@ -165,23 +443,43 @@ func (e *TranscriptionEngine) CreateJob(jobID, fileName, audioPath, language str
 }
 
 func (e *TranscriptionEngine) GetJob(jobID string) *Job {
+	job, _ := e.JobSnapshot(jobID)
+	return job
+}
+
+// JobSnapshot returns a copy of the job like GetJob, along with the Seq of
+// the last job event it reflects
+func (e *TranscriptionEngine) JobSnapshot(jobID string) (*Job, uint64) {
 	e.jobsMutex.RLock()
 	defer e.jobsMutex.RUnlock()
 
 	if job, ok := e.jobs[jobID]; ok {
 		jobCopy := *job
-		return &jobCopy
+		if job.Partial != nil {
+			// The worker keeps appending to the partial result, so hand out a snapshot
+			partial := *job.Partial
+			jobCopy.Partial = &partial
+		}
+		return &jobCopy, e.eventSeq
 	}
-	return nil
+	return nil, e.eventSeq
 }
 
 func (e *TranscriptionEngine) GetQueue() ([]Job, []Job) {
-	e.queueMutex.Lock()
-	defer e.queueMutex.Unlock()
+	queued, completed, _ := e.QueueSnapshot()
+	return queued, completed
+}
 
+// QueueSnapshot returns the queued and finished jobs like GetQueue, along
+// with the Seq of the last job event they reflect
+func (e *TranscriptionEngine) QueueSnapshot() ([]Job, []Job, uint64) {
+	// Same lock order as updateQueuePositions: jobsMutex first, then queueMutex
 	e.jobsMutex.RLock()
 	defer e.jobsMutex.RUnlock()
 
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+
 	// Get jobs in queue (queued + processing)
 	queuedJobs := make([]Job, 0)
 	for _, jobID := range e.queue {
@@ -221,7 +519,7 @@ func (e *TranscriptionEngine) GetQueue() ([]Job, []Job) {
 		}
 	}
 
-	return queuedJobs, completedJobs
+	return queuedJobs, completedJobs, e.eventSeq
 }
 
 func (e *TranscriptionEngine) updateQueuePositions() {
@@ -232,227 +530,279 @@ func (e *TranscriptionEngine) updateQueuePositions() {
 	e.queueMutex.Lock()
 	defer e.queueMutex.Unlock()
 
-	for i, jobID := range e.queue {
-		if job, ok := e.jobs[jobID]; ok {
-			job.QueuePosition = i + 1
-			if i == 0 && e.isProcessing {
+	position := 0
+	for _, jobID := range e.queue {
+		job, ok := e.jobs[jobID]
+		if !ok {
+			continue
+		}
+		oldPosition, oldMessage := job.QueuePosition, job.Message
+		if e.activeJobs[jobID] {
+			job.QueuePosition = 0
+			if job.Status == StatusQueued {
 				job.Message = "Processing..."
-			} else {
-				job.Message = fmt.Sprintf("Waiting in queue (position %d)", i+1)
 			}
+		} else {
+			position++
+			job.QueuePosition = position
+			job.Message = fmt.Sprintf("Waiting in queue (position %d)", position)
+		}
+		if job.QueuePosition != oldPosition || job.Message != oldMessage {
+			e.emitLocked(JobEventUpdated, job)
 		}
 	}
 }
 
-func (e *TranscriptionEngine) processQueue() {
+// nextQueuedJobLocked returns the first job in the queue that no worker slot
+// has picked up yet. Callers must hold queueMutex.
+func (e *TranscriptionEngine) nextQueuedJobLocked() (string, bool) {
+	for _, jobID := range e.queue {
+		if !e.activeJobs[jobID] {
+			return jobID, true
+		}
+	}
+	return "", false
+}
+
+// removeFromQueueLocked drops jobID from the queue. Callers must hold
+// queueMutex.
+func (e *TranscriptionEngine) removeFromQueueLocked(jobID string) bool {
+	for i, queuedJobID := range e.queue {
+		if queuedJobID == jobID {
+			e.queue = append(e.queue[:i:i], e.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// processQueue runs jobs from the queue one at a time. One processor runs
+// per worker slot, so up to workerCount jobs are transcribed concurrently.
+func (e *TranscriptionEngine) processQueue(slot int) {
 	for {
 		e.queueMutex.Lock()
 
-		// Wait while queue is empty
-		for len(e.queue) == 0 {
+		// Wait until there is a job no other slot has picked up
+		jobID, ok := e.nextQueuedJobLocked()
+		for !ok {
 			e.processingCond.Wait()
+			jobID, ok = e.nextQueuedJobLocked()
 		}
 
-		// Get next job from queue
-		jobID := e.queue[0]
-		e.isProcessing = true
+		e.activeJobs[jobID] = true
 		e.queueMutex.Unlock()
 
+		e.updateQueuePositions()
+
 		// Get job details
 		e.jobsMutex.RLock()
 		job := e.jobs[jobID]
 		audioPath := ""
-		language := ""
 		fileName := ""
+		var opts JobOptions
 		wasCancelled := false
 		if job != nil {
 			audioPath = job.AudioPath
-			language = job.Language
 			fileName = job.FileName
+			opts = job.JobOptions
 			wasCancelled = (job.Status == StatusFailed && job.Error == "Cancelled by user")
 		}
 		e.jobsMutex.RUnlock()
 
 		if job != nil && audioPath != "" && !wasCancelled {
-			log.Printf("[Queue] Processing job %s (%s)", jobID, fileName)
+			log.Printf("[Queue] Slot %d processing job %s (%s)", slot, jobID, fileName)
 
 			// Actually call Transcribe - this blocks until complete
-			e.Transcribe(context.Background(), jobID, audioPath, language, fileName)
+			e.Transcribe(context.Background(), slot, jobID, audioPath, fileName, opts)
 
-			// Clean up audio file
-			os.Remove(audioPath)
+			e.removeAudio(audioPath)
 		} else if wasCancelled {
 			log.Printf("[Queue] Skipping cancelled job %s (%s)", jobID, fileName)
-			// Clean up audio file
-			if audioPath != "" {
-				os.Remove(audioPath)
-			}
+			e.removeAudio(audioPath)
 		}
 
 		// Remove from queue
 		e.queueMutex.Lock()
-		if len(e.queue) > 0 {
-			e.queue = e.queue[1:]
-		}
-		e.isProcessing = false
+		e.removeFromQueueLocked(jobID)
+		delete(e.activeJobs, jobID)
+		remaining := len(e.queue)
 		e.queueMutex.Unlock()
 
 		e.updateQueuePositions()
-		log.Printf("[Queue] Job %s completed, %d jobs remaining", jobID, len(e.queue))
+		log.Printf("[Queue] Slot %d finished job %s, %d jobs remaining", slot, jobID, remaining)
 	}
 }
 
-func (e *TranscriptionEngine) Transcribe(ctx context.Context, jobID, audioPath, language, originalFileName string) {
-	duration, err := getAudioDuration(audioPath)
-	if err != nil {
-		e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Failed to get audio duration: %v", err))
-		return
+func (e *TranscriptionEngine) Transcribe(ctx context.Context, slot int, jobID, audioPath, originalFileName string, opts JobOptions) {
+	// Jobs restored from before per-job models use the default
+	model := opts.Model
+	if model == "" {
+		model = e.models.Default()
 	}
-
-	var speedFactor float64
-	if runtime.GOARCH == "arm64" && runtime.GOOS == "darwin" {
-		speedFactor = 6.0
-	} else {
-		speedFactor = 1.5
-	}
-
-	expectedTime := duration / speedFactor
-	startTime := time.Now()
-
-	stopEstimator := make(chan struct{})
-	go e.estimateProgress(jobID, startTime, expectedTime, stopEstimator)
-
-	// Prepare worker request
-	type WorkerRequest struct {
-		JobID     string `json:"jobID"`
-		AudioPath string `json:"audioPath"`
-		ModelPath string `json:"modelPath"`
-		Language  string `json:"language"`
-	}
-
-	req := WorkerRequest{
-		JobID:     jobID,
-		AudioPath: audioPath,
-		ModelPath: e.modelPath,
-		Language:  language,
-	}
-
-	reqJSON, err := json.Marshal(req)
-	if err != nil {
-		close(stopEstimator)
-		e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Failed to create worker request: %v", err))
+	if !e.models.IsInstalled(model) {
+		e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Model %q is not installed", model))
 		return
 	}
 
-	// Get the worker binary path - use absolute path of current executable
-	exePath, err := os.Executable()
+	duration, err := getAudioDuration(audioPath)
 	if err != nil {
-		close(stopEstimator)
-		e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Failed to get executable path: %v", err))
+		e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Failed to get audio duration: %v", err))
 		return
 	}
-	workerPath := filepath.Join(filepath.Dir(exePath), "transcriber-worker")
-	log.Printf("[Job %s] Starting worker: %s", jobID, workerPath)
+	log.Printf("[Job %s] Audio duration: %.1fs", jobID, duration)
 
-	// Start worker process
-	cmd := exec.Command(workerPath, string(reqJSON))
-	cmd.Stderr = os.Stderr
-
-	// Store the command so we can kill it later
-	e.workerMutex.Lock()
-	e.workerCmd = cmd
-	e.workerMutex.Unlock()
-
-	// Run worker and capture output
-	output, err := cmd.Output()
+	passes := []workerPass{{}}
+	if opts.SplitChannels {
+		channels, err := getAudioChannels(audioPath)
+		if err != nil {
+			e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Failed to get audio channels: %v", err))
+			return
+		}
+		if channels < 2 {
+			e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Channel separation needs a recording with at least 2 channels, this one has %d", channels))
+			return
+		}
+		passes = channelPasses(channels, opts.Speakers)
+		log.Printf("[Job %s] Transcribing %d channels separately", jobID, channels)
+	}
 
-	// Clear the worker command
-	e.workerMutex.Lock()
-	e.workerCmd = nil
-	e.workerMutex.Unlock()
+	e.updateJob(jobID, StatusProcessing, 0, "Starting worker...", "", nil, "")
+
+	req := protocol.Request{
+		AudioPath:      audioPath,
+		ModelPath:      e.models.Path(model),
+		Language:       opts.Language,
+		Threads:        e.threadsPerWorker(),
+		InitialPrompt:  opts.Prompt,
+		Temperature:    opts.Temperature,
+		Translate:      opts.Translate,
+		WordTimestamps: opts.WordTimestamps,
+	}
 
-	close(stopEstimator)
+	var passResults []*protocol.Result
+	for _, pass := range passes {
+		// Don't start a worker for a job that was cancelled while it was being prepared
+		if e.IsCancelled(jobID) {
+			log.Printf("[Job %s] Job was cancelled before the worker started", jobID)
+			e.finishCancelled(jobID)
+			return
+		}
 
-	log.Printf("[Job %s] Worker finished, output length: %d bytes", jobID, len(output))
-	if len(output) > 0 && len(output) < 1000 {
-		log.Printf("[Job %s] Worker output: %s", jobID, string(output))
-	}
+		if opts.SplitChannels {
+			req.Channel = pass.Index + 1
+		}
+		resp, err := e.runWorker(slot, jobID, req, pass)
 
-	// Check if job was killed/cancelled
-	if e.IsCancelled(jobID) {
-		log.Printf("[Job %s] Job was cancelled", jobID)
-		e.updateJob(jobID, StatusFailed, 0, "", "", nil, "Cancelled by user")
-		return
-	}
+		// Check if job was killed/cancelled
+		if e.IsCancelled(jobID) {
+			log.Printf("[Job %s] Job was cancelled", jobID)
+			e.finishCancelled(jobID)
+			return
+		}
 
-	if err != nil {
-		log.Printf("[Job %s] Worker error: %v", jobID, err)
-		e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Worker failed: %v", err))
-		return
-	}
+		if err != nil {
+			log.Printf("[Job %s] Worker error: %v", jobID, err)
+			e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Worker failed: %v", err))
+			return
+		}
 
-	// Parse worker response
-	type WorkerResponse struct {
-		Success  bool                     `json:"success"`
-		Text     string                   `json:"text,omitempty"`
-		Segments []TranscriptionSegment   `json:"segments,omitempty"`
-		Error    string                   `json:"error,omitempty"`
-		Duration float64                  `json:"duration"`
+		if pass.Speaker != "" {
+			for i := range resp.Segments {
+				resp.Segments[i].Speaker = pass.Speaker
+			}
+		}
+		passResults = append(passResults, resp)
 	}
-
-	var resp WorkerResponse
-	if err := json.Unmarshal(output, &resp); err != nil {
-		e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Failed to parse worker response: %v", err))
-		return
+	resp := mergePassResults(passResults)
+
+	turns := opts.SpeakerTurns
+	if opts.Diarize {
+		e.updateJob(jobID, StatusTranscribing, 99, "Identifying speakers...", "", nil, "")
+		turns, err = e.runDiarizeCommand(jobID, audioPath, duration)
+		if e.IsCancelled(jobID) {
+			log.Printf("[Job %s] Job was cancelled", jobID)
+			e.finishCancelled(jobID)
+			return
+		}
+		if err != nil {
+			log.Printf("[Job %s] Diarization error: %v", jobID, err)
+			e.updateJob(jobID, StatusFailed, 0, "", "", nil, fmt.Sprintf("Diarization failed: %v", err))
+			return
+		}
+		log.Printf("[Job %s] Diarization found %d speaker turns", jobID, len(turns))
 	}
+	resp.Segments = assignSpeakers(resp.Segments, turns)
 
-	if !resp.Success {
-		e.updateJob(jobID, StatusFailed, 0, "", "", nil, resp.Error)
-		return
+	language := opts.Language
+	if (language == "" || language == "auto") && resp.Language != "" {
+		language = resp.Language
 	}
 
 	result := &TranscriptionResult{
-		Text:     resp.Text,
-		Segments: resp.Segments,
-		Language: language,
+		Text:         resp.Text,
+		Segments:     resp.Segments,
+		Language:     language,
+		Model:        model,
+		Duration:     duration,
+		Task:         opts.Task(),
+		LinkedJobID:  opts.LinkedJobID,
+		Prompt:       opts.Prompt,
+		Vocabulary:   opts.Vocabulary,
+		TermsDropped: opts.TermsDropped,
 	}
 
 	e.updateJob(jobID, StatusCompleted, 100, "Completed", "", result, "")
 
 	// Save transcription to disk
-	if err := saveTranscription(result, originalFileName); err != nil {
-		log.Printf("[Job %s] Warning: Failed to save transcription to disk: %v", jobID, err)
+	if !e.headless {
+		if err := saveTranscription(e.config.OutputDir, jobID, result, originalFileName); err != nil {
+			log.Printf("[Job %s] Warning: Failed to save transcription to disk: %v", jobID, err)
+		}
 	}
 }
 
-func (e *TranscriptionEngine) estimateProgress(jobID string, startTime time.Time, expectedTime float64, stop chan struct{}) {
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
+// appendPartialSegment adds a segment decoded by the worker to the job's
+// partial result
+func (e *TranscriptionEngine) appendPartialSegment(jobID string, segment TranscriptionSegment) {
+	e.jobsMutex.Lock()
+	defer e.jobsMutex.Unlock()
 
-	for {
-		select {
-		case <-stop:
-			return
-		case <-ticker.C:
-			// Check if job was cancelled
-			if e.IsCancelled(jobID) {
-				log.Printf("[Job %s] Detected cancellation during progress estimation", jobID)
-				return
-			}
+	job, ok := e.jobs[jobID]
+	if !ok {
+		return
+	}
+	if job.Partial == nil {
+		job.Partial = &TranscriptionResult{Language: job.Language}
+	}
+	job.Partial.Text += segment.Text + " "
+	job.Partial.Segments = append(job.Partial.Segments, segment)
+	e.emitEventLocked(JobEvent{Type: JobEventSegment, Job: *job, Segment: &segment})
+}
 
-			elapsed := time.Since(startTime).Seconds()
-			progress := (elapsed / expectedTime) * 100
-			if progress > 99 {
-				progress = 99
-			}
+// progressTracker derives an ETA from the rate of real progress events
+type progressTracker struct {
+	startTime     time.Time
+	startProgress float64
+}
 
-			// Calculate ETA
-			remaining := expectedTime - elapsed
-			eta := formatDuration(remaining)
+// update records a progress event and returns the estimated time remaining
+func (t *progressTracker) update(progress float64) string {
+	if t.startTime.IsZero() {
+		// Measure the rate from the first event on, so model loading and
+		// audio decoding don't skew the estimate
+		t.startTime = time.Now()
+		t.startProgress = progress
+		return "Estimating..."
+	}
 
-			e.updateJob(jobID, StatusTranscribing, progress, fmt.Sprintf("Transcribing... %.0f%%", progress), eta, nil, "")
-		}
+	done := progress - t.startProgress
+	if done <= 0 {
+		return "Estimating..."
 	}
+
+	elapsed := time.Since(t.startTime).Seconds()
+	remaining := elapsed / done * (100 - progress)
+	return formatDuration(remaining)
 }
 
 func formatDuration(seconds float64) string {
@@ -475,21 +825,122 @@ func formatDuration(seconds float64) string {
 }
 
 func (e *TranscriptionEngine) updateJob(jobID string, status JobStatus, progress float64, message string, eta string, result *TranscriptionResult, errorMsg string) {
+	// A cancelled or killed job keeps its final state while its worker winds down
+	if status != StatusFailed && e.IsCancelled(jobID) {
+		return
+	}
+
 	e.jobsMutex.Lock()
 	defer e.jobsMutex.Unlock()
 
 	if job, ok := e.jobs[jobID]; ok {
+		// Progress ticks are not persisted, only state transitions
+		changed := job.Status != status || result != nil || (errorMsg != "" && errorMsg != job.Error)
+		event := ""
+		if job.Status != status {
+			event = jobEventFor(status, errorMsg)
+		}
+
 		job.Status = status
 		job.Progress = progress
 		job.Message = message
 		job.ETA = eta
 		if result != nil {
 			job.Result = result
+			job.Partial = nil
 		}
 		if errorMsg != "" {
 			job.Error = errorMsg
 		}
+
+		if changed {
+			e.saveJobLocked(job)
+		}
+		if event == "" {
+			event = JobEventUpdated
+		}
+		e.emitLocked(event, job)
+	}
+}
+
+// finishCancelled settles a job whose transcription was stopped by CancelJob
+// or KillJob. The reason they recorded is kept, so a killed job isn't
+// reported as cancelled.
+func (e *TranscriptionEngine) finishCancelled(jobID string) {
+	e.jobsMutex.Lock()
+	defer e.jobsMutex.Unlock()
+
+	job, ok := e.jobs[jobID]
+	if !ok {
+		return
+	}
+	job.Progress = 0
+	job.ETA = ""
+	if job.Status != StatusFailed {
+		job.Status = StatusFailed
+		job.Error = "Cancelled by user"
+		e.emitLocked(JobEventCancelled, job)
+	}
+	if job.Message == "Cancelling..." {
+		job.Message = "Cancelled"
+	}
+	e.saveJobLocked(job)
+}
+
+// channelPasses returns one worker pass per channel, each labelled with its
+// speaker
+func channelPasses(channels int, speakers []string) []workerPass {
+	passes := make([]workerPass, channels)
+	for i := range passes {
+		speaker := fmt.Sprintf("Channel %d", i+1)
+		if i < len(speakers) && strings.TrimSpace(speakers[i]) != "" {
+			speaker = strings.TrimSpace(speakers[i])
+		}
+		passes[i] = workerPass{Index: i, Count: channels, Label: fmt.Sprintf("channel %d", i+1), Speaker: speaker}
+	}
+	return passes
+}
+
+// mergePassResults interleaves the segments of several passes by start time
+func mergePassResults(results []*protocol.Result) *protocol.Result {
+	if len(results) == 1 {
+		return results[0]
+	}
+
+	merged := &protocol.Result{}
+	for _, result := range results {
+		merged.Segments = append(merged.Segments, result.Segments...)
+		merged.Duration += result.Duration
+		if merged.Language == "" {
+			merged.Language = result.Language
+		}
+	}
+	sort.SliceStable(merged.Segments, func(i, j int) bool {
+		return merged.Segments[i].Start < merged.Segments[j].Start
+	})
+
+	var text strings.Builder
+	for _, segment := range merged.Segments {
+		text.WriteString(segment.Text + " ")
+	}
+	merged.Text = text.String()
+	return merged
+}
+
+// getAudioChannels returns the channel count of the first audio stream
+func getAudioChannels(audioPath string) (int, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=channels",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		audioPath)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, err
 	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
 }
 
 func getAudioDuration(audioPath string) (float64, error) {
@@ -514,53 +965,14 @@ func getAudioDuration(audioPath string) (float64, error) {
 }
 
 func (e *TranscriptionEngine) Close() {
-	if e.model != nil {
-		e.model.Close()
+	e.stopWorkers()
+	if e.store != nil {
+		e.store.Close()
 	}
 }
 
-// getOutputDir returns the platform-specific directory for saving transcriptions
-func getOutputDir() (string, error) {
-	var baseDir string
-
-	if runtime.GOOS == "darwin" {
-		// macOS: Use ~/.transcriber-pro
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		baseDir = filepath.Join(homeDir, ".transcriber-pro")
-	} else if runtime.GOOS == "windows" {
-		// Windows: Use executable directory/transcriptions
-		exePath, err := os.Executable()
-		if err != nil {
-			return "", err
-		}
-		baseDir = filepath.Join(filepath.Dir(exePath), "transcriptions")
-	} else {
-		// Linux/Other: Use ~/transcriber-pro
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		baseDir = filepath.Join(homeDir, "transcriber-pro")
-	}
-
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(baseDir, 0755); err != nil {
-		return "", err
-	}
-
-	return baseDir, nil
-}
-
 // saveTranscription saves the transcription result to disk in multiple formats
-func saveTranscription(result *TranscriptionResult, originalFileName string) error {
-	outputDir, err := getOutputDir()
-	if err != nil {
-		return fmt.Errorf("failed to get output directory: %w", err)
-	}
-
+func saveTranscription(outputDir, jobID string, result *TranscriptionResult, originalFileName string) error {
 	// Create timestamp prefix: YYYYMMDD_HHMMSS
 	timestamp := time.Now().Format("20060102_150405")
 
@@ -576,64 +988,22 @@ func saveTranscription(result *TranscriptionResult, originalFileName string) err
 		return fmt.Errorf("failed to create output folder: %w", err)
 	}
 
-	// Save as TXT
-	txtPath := filepath.Join(outputFolder, "transcript.txt")
-	if err := os.WriteFile(txtPath, []byte(result.Text), 0644); err != nil {
-		return fmt.Errorf("failed to save TXT: %w", err)
-	}
-
-	// Save as JSON
-	jsonPath := filepath.Join(outputFolder, "transcript.json")
-	jsonData, err := json.MarshalIndent(result, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %w", err)
-	}
-	if err := os.WriteFile(jsonPath, jsonData, 0644); err != nil {
-		return fmt.Errorf("failed to save JSON: %w", err)
+	// Both tasks of one upload can land in the same folder
+	base := "transcript"
+	if result.Task == taskTranslate {
+		base = "translation"
 	}
-
-	// Save as SRT
-	srtPath := filepath.Join(outputFolder, "transcript.srt")
-	srtContent := generateSRT(result.Segments)
-	if err := os.WriteFile(srtPath, []byte(srtContent), 0644); err != nil {
-		return fmt.Errorf("failed to save SRT: %w", err)
+	for _, format := range savedFormats {
+		opts := ExportOptions{JobID: jobID, Source: originalFileName}
+		if _, err := writeExport(outputFolder, base, result, format, opts); err != nil {
+			return err
+		}
 	}
 
 	log.Printf("Transcription saved to: %s", outputFolder)
 	return nil
 }
 
-// generateSRT creates SRT subtitle format from segments
-func generateSRT(segments []TranscriptionSegment) string {
-	var srt strings.Builder
-
-	for i, segment := range segments {
-		// Segment number
-		srt.WriteString(fmt.Sprintf("%d\n", i+1))
-
-		// Timestamps
-		startTime := formatSRTTime(segment.Start)
-		endTime := formatSRTTime(segment.End)
-		srt.WriteString(fmt.Sprintf("%s --> %s\n", startTime, endTime))
-
-		// Text
-		srt.WriteString(segment.Text)
-		srt.WriteString("\n\n")
-	}
-
-	return srt.String()
-}
-
-// formatSRTTime formats seconds to SRT timestamp format (HH:MM:SS,mmm)
-func formatSRTTime(seconds float64) string {
-	hours := int(seconds / 3600)
-	minutes := int((seconds - float64(hours*3600)) / 60)
-	secs := int(seconds - float64(hours*3600) - float64(minutes*60))
-	millis := int((seconds - float64(int(seconds))) * 1000)
-
-	return fmt.Sprintf("%02d:%02d:%02d,%03d", hours, minutes, secs, millis)
-}
-
 func (e *TranscriptionEngine) ClearCompletedJobs() {
 	e.jobsMutex.Lock()
 	defer e.jobsMutex.Unlock()
@@ -654,6 +1024,8 @@ func (e *TranscriptionEngine) ClearCompletedJobs() {
 			}
 			if !inQueue {
 				delete(e.jobs, jobID)
+				e.deleteJobLocked(jobID)
+				e.emitLocked(JobEventRemoved, job)
 			}
 		}
 	}
@@ -661,26 +1033,30 @@ func (e *TranscriptionEngine) ClearCompletedJobs() {
 	log.Printf("[Queue] Cleared completed jobs")
 }
 
-// ClearAllJobs clears all jobs (both queued and completed), except the currently processing one
+// ClearAllJobs clears all jobs (both queued and completed), except the ones currently processing
 func (e *TranscriptionEngine) ClearAllJobs() {
 	e.jobsMutex.Lock()
 	e.queueMutex.Lock()
 
-	// Keep only the first job in queue if it's processing
-	var currentJobID string
-	if len(e.queue) > 0 && e.isProcessing {
-		currentJobID = e.queue[0]
-		// Clear the queue except for the first (processing) job
-		e.queue = e.queue[:1]
-	} else {
-		// No job is processing, clear entire queue
-		e.queue = nil
+	// Keep only the jobs a worker slot is processing
+	remaining := make([]string, 0, len(e.activeJobs))
+	for _, jobID := range e.queue {
+		if e.activeJobs[jobID] {
+			remaining = append(remaining, jobID)
+		}
 	}
+	e.queue = remaining
 
-	// Delete all jobs except the one currently processing
-	for jobID := range e.jobs {
-		if jobID != currentJobID {
+	// Delete all jobs except the ones currently processing
+	for jobID, job := range e.jobs {
+		if !e.activeJobs[jobID] {
+			// Queued uploads are never picked up by the processor now
+			if job.Status == StatusQueued {
+				e.removeAudio(job.AudioPath)
+			}
 			delete(e.jobs, jobID)
+			e.deleteJobLocked(jobID)
+			e.emitLocked(JobEventRemoved, job)
 		}
 	}
 
@@ -701,33 +1077,18 @@ func (e *TranscriptionEngine) CancelJob(jobID string) error {
 
 	// Find and remove from queue
 	e.queueMutex.Lock()
-	found := false
-	isFirstJob := false
-	isProcessingJob := false
-	newQueue := make([]string, 0)
-	for i, queuedJobID := range e.queue {
-		if queuedJobID == jobID {
-			found = true
-			isFirstJob = (i == 0)
-			isProcessingJob = isFirstJob && e.isProcessing
-			log.Printf("[Queue] Cancelling job %s (isProcessing: %v, isFirstJob: %v)", jobID, e.isProcessing, isFirstJob)
-			// Don't add to new queue
-			continue
-		}
-		newQueue = append(newQueue, queuedJobID)
-	}
-
-	if !found {
+	isProcessingJob := e.activeJobs[jobID]
+	if !e.removeFromQueueLocked(jobID) {
 		e.queueMutex.Unlock()
 		return fmt.Errorf("job not found in queue")
 	}
-
-	e.queue = newQueue
+	log.Printf("[Queue] Cancelling job %s (isProcessing: %v)", jobID, isProcessingJob)
 	e.queueMutex.Unlock()
 
 	// Now update job status (separate lock, after releasing queueMutex)
 	e.jobsMutex.Lock()
 	if job, ok := e.jobs[jobID]; ok {
+		wasFailed := job.Status == StatusFailed
 		job.Status = StatusFailed
 		job.Error = "Cancelled by user"
 		if isProcessingJob {
@@ -735,14 +1096,34 @@ func (e *TranscriptionEngine) CancelJob(jobID string) error {
 		} else {
 			job.Message = "Cancelled"
 		}
+		e.saveJobLocked(job)
+		if !wasFailed {
+			e.emitLocked(JobEventCancelled, job)
+		}
 	}
 	e.jobsMutex.Unlock()
 
+	// Stop this job's worker so its slot frees up for the next job
+	if isProcessingJob {
+		if err := e.killWorker(jobID, "cancel"); err != nil {
+			log.Printf("[Job %s] Failed to stop worker: %v", jobID, err)
+		}
+	}
+
 	// Update queue positions
 	e.updateQueuePositions()
 	return nil
 }
 
+// removeAudio deletes an uploaded audio file the engine no longer needs.
+// Headless engines transcribe the user's own files, so they are kept.
+func (e *TranscriptionEngine) removeAudio(path string) {
+	if path == "" || e.headless {
+		return
+	}
+	os.Remove(path)
+}
+
 // IsCancelled checks if a job has been cancelled
 func (e *TranscriptionEngine) IsCancelled(jobID string) bool {
 	e.cancelledJobsMux.RLock()
@@ -750,34 +1131,56 @@ func (e *TranscriptionEngine) IsCancelled(jobID string) bool {
 	return e.cancelledJobs[jobID]
 }
 
-// KillJob kills the currently running worker process
+// killWorker kills the worker process running jobID, if there is one
+func (e *TranscriptionEngine) killWorker(jobID, reason string) error {
+	_, err := e.workers.kill(jobID, reason)
+	return err
+}
+
+// KillJob kills the worker process running the given job. It fails with
+// ErrJobNotFound or ErrJobNotRunning without touching any process when the
+// job is unknown or not currently being processed.
 func (e *TranscriptionEngine) KillJob(jobID string) error {
-	// Mark job as cancelled
+	e.jobsMutex.Lock()
+	job, ok := e.jobs[jobID]
+	if !ok {
+		e.jobsMutex.Unlock()
+		return ErrJobNotFound
+	}
+
+	// A finished job stays active until its slot has cleaned up, and must
+	// keep its result or error
+	e.queueMutex.Lock()
+	running := e.activeJobs[jobID]
+	e.queueMutex.Unlock()
+	if !running || job.Status == StatusCompleted || job.Status == StatusFailed {
+		status := job.Status
+		e.jobsMutex.Unlock()
+		return fmt.Errorf("%w (status: %s)", ErrJobNotRunning, status)
+	}
+
+	// Mark job as cancelled so the slot discards whatever the worker returns
 	e.cancelledJobsMux.Lock()
 	e.cancelledJobs[jobID] = true
 	e.cancelledJobsMux.Unlock()
 
-	// Kill the worker process if it's running
-	e.workerMutex.Lock()
-	cmd := e.workerCmd
-	e.workerMutex.Unlock()
-
-	if cmd != nil && cmd.Process != nil {
-		log.Printf("[Job %s] Killing worker process (PID: %d)", jobID, cmd.Process.Pid)
-		if err := cmd.Process.Kill(); err != nil {
-			return fmt.Errorf("failed to kill worker process: %w", err)
-		}
-		log.Printf("[Job %s] Worker process killed successfully", jobID)
-	}
+	// Mark job as failed before the worker dies, so the slot keeps this reason
+	job.Status = StatusFailed
+	job.Error = "Killed by user"
+	job.Message = "Killed"
+	e.saveJobLocked(job)
+	e.emitLocked(JobEventKilled, job)
+	e.jobsMutex.Unlock()
 
-	// Mark job as failed
-	e.jobsMutex.Lock()
-	if job, ok := e.jobs[jobID]; ok {
-		job.Status = StatusFailed
-		job.Error = "Killed by user"
-		job.Message = "Killed"
+	// Kill the worker process if it has started
+	if err := e.killWorker(jobID, "kill"); err != nil {
+		return err
 	}
-	e.jobsMutex.Unlock()
 
 	return nil
 }
+
+// KillAudit returns the most recent worker terminations, oldest first
+func (e *TranscriptionEngine) KillAudit() []KillRecord {
+	return e.workers.Audit()
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Vocabularies are named lists of terms, such as product names, people and
+// acronyms, that Whisper tends to misspell. A job that names one gets its
+// terms in the initial prompt, which biases the decoder towards spelling them
+// that way.
+
+const (
+	maxVocabularyTerms = 200
+	maxTermLength      = 100 // Characters
+
+	// maxPromptLength is the longest prompt, in characters, that fits
+	// Whisper's 224-token prompt window at a conservative three characters
+	// per token; names and acronyms tokenize worse than ordinary words.
+	// whisper.cpp keeps only the end of longer prompts.
+	maxPromptLength = 224 * 3
+)
+
+// vocabularyNamePattern is what a vocabulary may be called; names appear in
+// URLs and form fields
+var vocabularyNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+var ErrVocabularyNotFound = errors.New("vocabulary not found")
+
+// Vocabulary is a named list of terms
+type Vocabulary struct {
+	Name      string    `json:"name"`
+	Terms     []string  `json:"terms"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// VocabularyStore keeps the vocabularies in a JSON file in the state
+// directory, rewritten atomically on every change
+type VocabularyStore struct {
+	path string
+
+	mu           sync.RWMutex
+	vocabularies map[string]*Vocabulary // By name
+}
+
+// OpenVocabularyStore loads the vocabularies saved at path, if any
+func OpenVocabularyStore(path string) (*VocabularyStore, error) {
+	s := &VocabularyStore{path: path, vocabularies: make(map[string]*Vocabulary)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read vocabularies: %w", err)
+	}
+
+	var list []*Vocabulary
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, vocabulary := range list {
+		s.vocabularies[vocabulary.Name] = vocabulary
+	}
+	return s, nil
+}
+
+// List returns the vocabularies sorted by name
+func (s *VocabularyStore) List() []Vocabulary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Vocabulary, 0, len(s.vocabularies))
+	for _, vocabulary := range s.vocabularies {
+		list = append(list, *vocabulary)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Get returns a vocabulary, or ErrVocabularyNotFound
+func (s *VocabularyStore) Get(name string) (*Vocabulary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vocabulary, ok := s.vocabularies[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrVocabularyNotFound, name)
+	}
+	copied := *vocabulary
+	return &copied, nil
+}
+
+// Put creates or replaces a vocabulary, and reports whether it is new. Terms
+// are cleaned up as by checkVocabulary.
+func (s *VocabularyStore) Put(name string, terms []string) (*Vocabulary, bool, error) {
+	terms, err := checkVocabulary(name, terms)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, exists := s.vocabularies[name]
+	vocabulary := &Vocabulary{Name: name, Terms: terms, UpdatedAt: time.Now().UTC()}
+	s.vocabularies[name] = vocabulary
+	if err := s.saveLocked(); err != nil {
+		if exists {
+			s.vocabularies[name] = previous
+		} else {
+			delete(s.vocabularies, name)
+		}
+		return nil, false, err
+	}
+	copied := *vocabulary
+	return &copied, !exists, nil
+}
+
+// Delete removes a vocabulary, or returns ErrVocabularyNotFound
+func (s *VocabularyStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vocabulary, ok := s.vocabularies[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrVocabularyNotFound, name)
+	}
+	delete(s.vocabularies, name)
+	if err := s.saveLocked(); err != nil {
+		s.vocabularies[name] = vocabulary
+		return err
+	}
+	return nil
+}
+
+// saveLocked writes the vocabularies to disk. Callers must hold mu.
+func (s *VocabularyStore) saveLocked() error {
+	list := make([]*Vocabulary, 0, len(s.vocabularies))
+	for _, vocabulary := range s.vocabularies {
+		list = append(list, vocabulary)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save vocabularies: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to save vocabularies: %w", err)
+	}
+	return nil
+}
+
+// checkVocabulary validates a vocabulary's name and terms, and returns the
+// terms trimmed, without blank and repeated ones
+func checkVocabulary(name string, terms []string) ([]string, error) {
+	if !vocabularyNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid vocabulary name %q (letters, digits, '.', '_' and '-', up to 64 characters)", name)
+	}
+
+	cleaned := make([]string, 0, len(terms))
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		term = strings.Join(strings.Fields(term), " ")
+		if term == "" || seen[term] {
+			continue
+		}
+		if utf8.RuneCountInString(term) > maxTermLength {
+			return nil, fmt.Errorf("term %q is longer than %d characters", term, maxTermLength)
+		}
+		seen[term] = true
+		cleaned = append(cleaned, term)
+	}
+	if len(cleaned) == 0 {
+		return nil, errors.New("a vocabulary needs at least one term")
+	}
+	if len(cleaned) > maxVocabularyTerms {
+		return nil, fmt.Errorf("a vocabulary can have at most %d terms, got %d", maxVocabularyTerms, len(cleaned))
+	}
+	return cleaned, nil
+}
+
+// buildPrompt combines a vocabulary's terms and an initial prompt into the
+// prompt given to Whisper, and returns how many terms it left out. The terms
+// come first so the caller's own text is what the decoder reads last. Since
+// whisper.cpp would cut the start of a prompt longer than the window, which
+// is where the terms are, terms are dropped from the end of the list until
+// the prompt fits in maxPromptLength; the caller's text is never shortened.
+func buildPrompt(initialPrompt string, vocabulary *Vocabulary) (string, int) {
+	initialPrompt = strings.TrimSpace(initialPrompt)
+	if vocabulary == nil || len(vocabulary.Terms) == 0 {
+		return initialPrompt, 0
+	}
+
+	room := maxPromptLength - utf8.RuneCountInString(initialPrompt)
+	if initialPrompt != "" {
+		room-- // The space between the terms and the prompt
+	}
+	length := 1 // The full stop after the terms
+	var terms []string
+	for _, term := range vocabulary.Terms {
+		n := utf8.RuneCountInString(term)
+		if len(terms) > 0 {
+			n += 2 // ", "
+		}
+		if length+n > room {
+			break
+		}
+		terms = append(terms, term)
+		length += n
+	}
+	dropped := len(vocabulary.Terms) - len(terms)
+
+	if len(terms) == 0 {
+		return initialPrompt, dropped
+	}
+	list := strings.Join(terms, ", ") + "."
+	if initialPrompt == "" {
+		return list, dropped
+	}
+	return list + " " + initialPrompt, dropped
+}
+
+// handleVocabularies serves GET /vocabularies and GET, PUT and DELETE
+// /vocabularies/{name}
+func handleVocabularies(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/vocabularies"), "/")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			sendJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"vocabularies": vocabularies.List()})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		vocabulary, err := vocabularies.Get(name)
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(vocabulary)
+
+	case http.MethodPut:
+		var body struct {
+			Terms []string `json:"terms"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body); err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if _, err := checkVocabulary(name, body.Terms); err != nil {
+			sendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		vocabulary, created, err := vocabularies.Put(name, body.Terms)
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(vocabulary)
+
+	case http.MethodDelete:
+		if err := vocabularies.Delete(name); errors.Is(err, ErrVocabularyNotFound) {
+			sendJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			sendJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted", "name": name})
+
+	default:
+		sendJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	watchPollInterval = 2 * time.Second
+	watchSettleTime   = 5 * time.Second // A file must stop changing for this long before it is picked up
+	processedDirName  = "processed"
+	failedDirName     = "failed"
+)
+
+// watchExtensions are the audio and video files picked up from watch folders
+var watchExtensions = map[string]bool{
+	".mp3": true, ".wav": true, ".m4a": true, ".aac": true, ".flac": true, ".ogg": true,
+	".opus": true, ".wma": true, ".aiff": true, ".mp4": true, ".mov": true, ".mkv": true,
+	".avi": true, ".webm": true, ".m4v": true,
+}
+
+// WatchFolder is a hot folder whose new recordings are transcribed
+// automatically. Finished sources are moved to processed/ together with the
+// transcripts in Formats; sources that fail go to failed/.
+type WatchFolder struct {
+	Path     string   `yaml:"path" json:"path"`
+	Language string   `yaml:"language" json:"language,omitempty"` // Default: the server's default language
+	Model    string   `yaml:"model" json:"model,omitempty"`       // Default: the server's default model
+	Formats  []string `yaml:"formats" json:"formats,omitempty"`   // Transcripts written next to the processed source (default txt)
+}
+
+// watchEntry is one file in the ingest ledger
+type watchEntry struct {
+	Key       string    `json:"key"` // Folder, name, size and modification time of the source
+	Folder    string    `json:"folder"`
+	File      string    `json:"file"`
+	JobID     string    `json:"job_id,omitempty"`
+	State     string    `json:"state"` // "queued", "processed" or "failed"
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Ledger states
+const (
+	watchQueued    = "queued"
+	watchProcessed = "processed"
+	watchFailed    = "failed"
+)
+
+// pendingFile is a file seen in a watch folder that is still being written
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	stable  time.Time // When size and modTime were last seen to change
+}
+
+// FolderWatcher polls the configured watch folders and feeds new recordings
+// into the engine. Every file it takes on is recorded in an append-only
+// ledger, so a file is never enqueued twice, even across restarts.
+type FolderWatcher struct {
+	engine     *TranscriptionEngine
+	folders    []WatchFolder
+	ledgerPath string
+
+	mu      sync.Mutex
+	ledger  map[string]*watchEntry // By key
+	pending map[string]*pendingFile
+}
+
+func NewFolderWatcher(engine *TranscriptionEngine, folders []WatchFolder, ledgerPath string) (*FolderWatcher, error) {
+	w := &FolderWatcher{
+		engine:     engine,
+		folders:    folders,
+		ledgerPath: ledgerPath,
+		ledger:     make(map[string]*watchEntry),
+		pending:    make(map[string]*pendingFile),
+	}
+
+	for _, folder := range folders {
+		for _, dir := range []string{folder.Path, filepath.Join(folder.Path, processedDirName), filepath.Join(folder.Path, failedDirName)} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create watch folder: %w", err)
+			}
+		}
+	}
+
+	if err := w.loadLedger(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// loadLedger replays the ledger; the latest record for a key wins
+func (w *FolderWatcher) loadLedger() error {
+	file, err := os.Open(w.ledgerPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open watch ledger: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry watchEntry
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+				log.Printf("[Watch] Ignoring unreadable ledger record: %v", jsonErr)
+			} else {
+				w.ledger[entry.Key] = &entry
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read watch ledger: %w", err)
+		}
+	}
+}
+
+// record updates an entry and appends it to the ledger. Callers must hold mu.
+func (w *FolderWatcher) record(entry *watchEntry) {
+	entry.UpdatedAt = time.Now()
+	w.ledger[entry.Key] = entry
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	file, err := os.OpenFile(w.ledgerPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Printf("[Watch] Warning: failed to update ledger: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err == nil {
+		file.Sync()
+	}
+}
+
+// Entries returns the ledger, most recently updated first
+func (w *FolderWatcher) Entries() []watchEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entries := make([]watchEntry, 0, len(w.ledger))
+	for _, entry := range w.ledger {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].UpdatedAt.After(entries[j].UpdatedAt)
+	})
+	return entries
+}
+
+// Folders returns the watched folders
+func (w *FolderWatcher) Folders() []WatchFolder {
+	return w.folders
+}
+
+// Run polls the folders until ctx is cancelled
+func (w *FolderWatcher) Run(ctx context.Context) {
+	for _, folder := range w.folders {
+		log.Printf("[Watch] Watching %s", folder.Path)
+	}
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.poll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll settles finished jobs and picks up new files
+func (w *FolderWatcher) poll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.settleJobs()
+	for _, folder := range w.folders {
+		w.scan(folder)
+	}
+}
+
+// scan looks for new, fully written files in a folder
+func (w *FolderWatcher) scan(folder WatchFolder) {
+	entries, err := os.ReadDir(folder.Path)
+	if err != nil {
+		log.Printf("[Watch] Failed to read %s: %v", folder.Path, err)
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, dirEntry := range entries {
+		name := dirEntry.Name()
+		if !dirEntry.Type().IsRegular() || strings.HasPrefix(name, ".") || !watchExtensions[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(folder.Path, name)
+		seen[path] = true
+		key := watchKey(folder.Path, name, info)
+		if _, done := w.ledger[key]; done {
+			continue
+		}
+
+		// Wait until the file has stopped growing
+		p, ok := w.pending[path]
+		if !ok || p.size != info.Size() || !p.modTime.Equal(info.ModTime()) {
+			w.pending[path] = &pendingFile{size: info.Size(), modTime: info.ModTime(), stable: now}
+			continue
+		}
+		if now.Sub(p.stable) < watchSettleTime || info.Size() == 0 {
+			continue
+		}
+
+		delete(w.pending, path)
+		w.enqueue(folder, path, key)
+	}
+
+	// Forget files that disappeared before they settled
+	for path := range w.pending {
+		if filepath.Dir(path) == folder.Path && !seen[path] {
+			delete(w.pending, path)
+		}
+	}
+}
+
+// watchKey identifies a source file. The size and modification time are part
+// of the key so a new recording saved under an old name is picked up again.
+func watchKey(dir, name string, info os.FileInfo) string {
+	return fmt.Sprintf("%s|%s|%d|%d", dir, name, info.Size(), info.ModTime().UnixNano())
+}
+
+// enqueue hands a settled file to the engine. The engine deletes its audio
+// once transcribed, so it gets a hard link (or copy) in the upload directory
+// and the source stays put until the job finishes.
+func (w *FolderWatcher) enqueue(folder WatchFolder, path, key string) {
+	name := filepath.Base(path)
+	entry := &watchEntry{Key: key, Folder: folder.Path, File: name}
+
+	model, err := w.engine.Models().Resolve(folder.Model)
+	if err != nil {
+		w.fail(entry, path, err.Error())
+		return
+	}
+
+	language := folder.Language
+	if language == "" {
+		language = w.engine.Config().DefaultLanguage
+	}
+
+	jobID := uuid.New().String()
+	audioPath := filepath.Join(w.engine.Config().UploadDir, jobID+filepath.Ext(name))
+	if err := linkOrCopy(path, audioPath); err != nil {
+		w.fail(entry, path, fmt.Sprintf("failed to stage file: %v", err))
+		return
+	}
+
+	entry.JobID = jobID
+	entry.State = watchQueued
+	w.record(entry)
+
+	w.engine.CreateJob(jobID, name, audioPath, JobOptions{Language: language, Model: model})
+	log.Printf("[Watch] Queued %s as job %s", path, jobID)
+}
+
+// settleJobs moves the sources of finished jobs out of the watch folder
+func (w *FolderWatcher) settleJobs() {
+	for _, entry := range w.ledger {
+		if entry.State != watchQueued {
+			continue
+		}
+
+		path := filepath.Join(entry.Folder, entry.File)
+		job := w.engine.GetJob(entry.JobID)
+		switch {
+		case job == nil:
+			w.fail(entry, path, "job was removed before it finished")
+		case job.Status == StatusFailed:
+			w.fail(entry, path, job.Error)
+		case job.Status == StatusCompleted:
+			w.complete(entry, path, job.Result)
+		}
+	}
+}
+
+// complete moves a transcribed source to processed/ and writes its
+// transcripts next to it
+func (w *FolderWatcher) complete(entry *watchEntry, path string, result *TranscriptionResult) {
+	dest, err := moveAside(path, filepath.Join(entry.Folder, processedDirName))
+	if err != nil {
+		log.Printf("[Watch] Failed to move %s: %v", path, err)
+	}
+
+	if result != nil {
+		base := strings.TrimSuffix(filepath.Base(dest), filepath.Ext(dest))
+		for _, format := range w.folderFormats(entry.Folder) {
+			if _, err := writeExport(filepath.Join(entry.Folder, processedDirName), base, result, format, ExportOptions{JobID: entry.JobID, Source: entry.File}); err != nil {
+				log.Printf("[Watch] %s: %v", entry.File, err)
+			}
+		}
+	}
+
+	entry.State = watchProcessed
+	w.record(entry)
+	log.Printf("[Watch] Processed %s", path)
+}
+
+// fail moves a source to failed/ and records why
+func (w *FolderWatcher) fail(entry *watchEntry, path, reason string) {
+	if _, err := moveAside(path, filepath.Join(entry.Folder, failedDirName)); err != nil {
+		log.Printf("[Watch] Failed to move %s: %v", path, err)
+	}
+
+	entry.State = watchFailed
+	entry.Error = reason
+	w.record(entry)
+	log.Printf("[Watch] Failed %s: %s", path, reason)
+}
+
+// folderFormats returns the transcript formats configured for a folder
+func (w *FolderWatcher) folderFormats(dir string) []string {
+	for _, folder := range w.folders {
+		if folder.Path == dir && len(folder.Formats) > 0 {
+			return folder.Formats
+		}
+	}
+	return []string{"txt"}
+}
+
+// moveAside moves path into dir, adding a timestamp if the name is taken, and
+// returns the new path. A missing source is not an error.
+func moveAside(path, dir string) (string, error) {
+	dest := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return dest, nil
+	}
+	if _, err := os.Stat(dest); err == nil {
+		ext := filepath.Ext(dest)
+		dest = fmt.Sprintf("%s_%s%s", strings.TrimSuffix(dest, ext), time.Now().Format("20060102_150405"), ext)
+	}
+	return dest, os.Rename(path, dest)
+}
+
+// linkOrCopy makes dst a hard link to src, copying it when the two are on
+// different filesystems
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	webhookAttempts  = 6
+	webhookRetryWait = time.Second // Doubled after every failed attempt
+	webhookTimeout   = 10 * time.Second
+
+	// maxWebhookLogEntries bounds the in-memory delivery log; the on-disk log
+	// keeps everything
+	maxWebhookLogEntries = 500
+)
+
+// WebhookPayload is the JSON body posted to webhooks
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	JobID     string    `json:"job_id"`
+	FileName  string    `json:"filename"`
+	Status    JobStatus `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	ResultURL string    `json:"result_url,omitempty"` // Set once the job has completed
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WebhookDelivery records one attempt to deliver an event
+type WebhookDelivery struct {
+	ID         string    `json:"id"` // Shared by every attempt of the same delivery
+	Event      string    `json:"event"`
+	JobID      string    `json:"job_id"`
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"`
+	Abandoned  bool      `json:"abandoned,omitempty"` // Retries stopped by a shutdown
+	Time       time.Time `json:"time"`
+}
+
+// WebhookNotifier posts job lifecycle events to the global webhook and to
+// each job's callback URL. Bodies are signed with HMAC-SHA256 when a secret
+// is configured, and failed deliveries are retried with exponential backoff
+// until Close.
+type WebhookNotifier struct {
+	url       string // Global webhook, empty for per-job callbacks only
+	secret    string
+	serverURL string // Base of result links
+	client    *http.Client
+
+	ctx        context.Context // Cancelled by Close
+	cancel     context.CancelFunc
+	deliveries sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool // Set by Close; later events are dropped
+	log     []WebhookDelivery
+	logPath string // Append-only delivery log, empty to keep it in memory only
+}
+
+func NewWebhookNotifier(cfg *Config) *WebhookNotifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookNotifier{
+		url:       cfg.WebhookURL,
+		secret:    cfg.WebhookSecret,
+		serverURL: cfg.ServerURL(),
+		client:    &http.Client{Timeout: webhookTimeout},
+		ctx:       ctx,
+		cancel:    cancel,
+		logPath:   filepath.Join(cfg.StateDir, "webhooks.jsonl"),
+	}
+}
+
+// Close stops pending retries and in-flight attempts, and waits until every
+// delivery has been recorded; the ones cut short are logged as abandoned.
+// Events notified after Close are ignored.
+func (n *WebhookNotifier) Close() {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+	n.cancel()
+	n.deliveries.Wait()
+}
+
+// Notify delivers lifecycle events in the background. It never blocks, so it can be
+// registered with TranscriptionEngine.OnJobEvent.
+func (n *WebhookNotifier) Notify(event JobEvent) {
+	if !event.IsLifecycle() {
+		return
+	}
+
+	payload := WebhookPayload{
+		Event:     event.Type,
+		JobID:     event.Job.ID,
+		FileName:  event.Job.FileName,
+		Status:    event.Job.Status,
+		Error:     event.Job.Error,
+		Timestamp: event.Time,
+	}
+	if event.Type == JobEventCompleted {
+		payload.ResultURL = n.serverURL + "/progress/" + event.Job.ID
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	// Deliveries are counted under mu so none starts once Close is waiting
+	targets := n.targets(event.Job.CallbackURL)
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.deliveries.Add(len(targets))
+	n.mu.Unlock()
+
+	for _, target := range targets {
+		go func() {
+			defer n.deliveries.Done()
+			n.deliver(target, payload, body)
+		}()
+	}
+}
+
+// targets returns the URLs an event goes to
+func (n *WebhookNotifier) targets(callbackURL string) []string {
+	var targets []string
+	if n.url != "" {
+		targets = append(targets, n.url)
+	}
+	if callbackURL != "" && callbackURL != n.url {
+		targets = append(targets, callbackURL)
+	}
+	return targets
+}
+
+// deliver posts body to target until it is accepted, the attempts run out,
+// or the notifier is closed
+func (n *WebhookNotifier) deliver(target string, payload WebhookPayload, body []byte) {
+	delivery := WebhookDelivery{
+		ID:    uuid.New().String(),
+		Event: payload.Event,
+		JobID: payload.JobID,
+		URL:   target,
+	}
+
+	wait := webhookRetryWait
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		delivery.Attempt = attempt
+		if attempt > 1 {
+			select {
+			case <-n.ctx.Done():
+				n.abandon(delivery)
+				return
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+
+		delivery.StatusCode, delivery.Error = n.post(target, delivery.ID, payload.Event, body)
+		if delivery.Error != "" && n.ctx.Err() != nil {
+			n.abandon(delivery)
+			return
+		}
+		delivery.Delivered = delivery.Error == ""
+		delivery.Time = time.Now()
+		n.record(delivery)
+
+		if delivery.Delivered {
+			return
+		}
+		log.Printf("[Webhook] %s for job %s to %s failed (attempt %d/%d): %s", payload.Event, payload.JobID, target, attempt, webhookAttempts, delivery.Error)
+	}
+}
+
+// abandon records that delivery's attempt was not made, or not finished,
+// because the notifier was closed
+func (n *WebhookNotifier) abandon(delivery WebhookDelivery) {
+	delivery.StatusCode = 0
+	delivery.Error = "abandoned at shutdown"
+	delivery.Abandoned = true
+	delivery.Time = time.Now()
+	n.record(delivery)
+	log.Printf("[Webhook] %s for job %s to %s abandoned at shutdown (attempt %d/%d)", delivery.Event, delivery.JobID, delivery.URL, delivery.Attempt, webhookAttempts)
+}
+
+// post makes one delivery attempt and returns the response status and an
+// error message, which is empty on success
+func (n *WebhookNotifier) post(target, deliveryID, event string, body []byte) (int, string) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "transcriber-pro/"+Version)
+	req.Header.Set("X-Transcriber-Event", event)
+	req.Header.Set("X-Transcriber-Delivery", deliveryID)
+	if n.secret != "" {
+		req.Header.Set("X-Transcriber-Signature", "sha256="+signWebhook(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, ""
+}
+
+// signWebhook returns the hex HMAC-SHA256 of body keyed with secret
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *WebhookNotifier) record(delivery WebhookDelivery) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.log = append(n.log, delivery)
+	if len(n.log) > maxWebhookLogEntries {
+		n.log = n.log[len(n.log)-maxWebhookLogEntries:]
+	}
+
+	if n.logPath == "" {
+		return
+	}
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return
+	}
+	file, err := os.OpenFile(n.logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Printf("[Webhook] Failed to open delivery log: %v", err)
+		return
+	}
+	defer file.Close()
+	file.Write(append(data, '\n'))
+}
+
+// Deliveries returns the most recent delivery attempts, oldest first,
+// optionally only those for one job
+func (n *WebhookNotifier) Deliveries(jobID string) []WebhookDelivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	deliveries := make([]WebhookDelivery, 0, len(n.log))
+	for _, delivery := range n.log {
+		if jobID == "" || delivery.JobID == jobID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// validateWebhookURL checks that raw is an absolute http or https URL
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q (must be http or https)", raw)
+	}
+	return nil
+}
@@ -2,75 +2,152 @@ package main
 
 import (
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	"github.com/hnrqer/transcriber-pro/protocol"
 )
 
-// WorkerRequest is the input data for the worker
-type WorkerRequest struct {
-	JobID      string `json:"jobID"`
-	AudioPath  string `json:"audioPath"`
-	ModelPath  string `json:"modelPath"`
-	Language   string `json:"language"`
-}
+var Version = "dev"
 
-// WorkerResponse is the output data from the worker
-type WorkerResponse struct {
-	Success   bool                   `json:"success"`
-	Text      string                 `json:"text,omitempty"`
-	Segments  []TranscriptionSegment `json:"segments,omitempty"`
-	Error     string                 `json:"error,omitempty"`
-	Duration  float64                `json:"duration"`
+// logWriter turns the worker's log output into log frames, keeping stdout
+// free of anything that is not a protocol frame
+type logWriter struct {
+	enc *protocol.Encoder
 }
 
-// TranscriptionSegment represents a single segment of transcribed text
-type TranscriptionSegment struct {
-	Start float64 `json:"start"`
-	End   float64 `json:"end"`
-	Text  string  `json:"text"`
+func (w logWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	if err := w.enc.Send(protocol.Message{Type: protocol.TypeLog, Log: &protocol.Log{Message: msg}}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatal("Usage: worker <request-json>")
+	if len(os.Args) > 1 && os.Args[1] == "--version" {
+		fmt.Println(Version)
+		return
 	}
 
-	// Parse request
-	var req WorkerRequest
-	if err := json.Unmarshal([]byte(os.Args[1]), &req); err != nil {
-		sendError(fmt.Sprintf("Failed to parse request: %v", err))
-		return
+	enc := protocol.NewEncoder(os.Stdout)
+	dec := protocol.NewDecoder(os.Stdin)
+
+	log.SetFlags(0)
+	log.SetOutput(logWriter{enc})
+
+	// Handshake: the server speaks first
+	msg, err := dec.Next()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Worker] Handshake failed: %v\n", err)
+		os.Exit(1)
+	}
+	if err := protocol.CheckHello(msg); err != nil {
+		enc.Send(protocol.Message{Type: protocol.TypeError, Error: &protocol.Error{Message: err.Error()}})
+		fmt.Fprintf(os.Stderr, "[Worker] %v\n", err)
+		os.Exit(2)
+	}
+	if err := enc.Send(protocol.NewHello(Version)); err != nil {
+		os.Exit(1)
+	}
+
+	// Serve requests until the server closes stdin, keeping the model loaded
+	// between them
+	cache := &modelCache{}
+	defer cache.close()
+
+	for {
+		msg, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			log.Printf("[Worker] Ignoring unreadable frame: %v", err)
+			continue
+		}
+		if msg.Type != protocol.TypeRequest || msg.Request == nil {
+			log.Printf("[Worker] Ignoring unexpected %q frame", msg.Type)
+			continue
+		}
+
+		result, err := transcribe(enc, cache, msg.JobID, *msg.Request)
+		if err != nil {
+			log.Printf("[Worker %s] Error: %v", msg.JobID, err)
+			enc.Send(protocol.Message{Type: protocol.TypeError, JobID: msg.JobID, Error: &protocol.Error{Message: err.Error()}})
+			continue
+		}
+		enc.Send(protocol.Message{Type: protocol.TypeResult, JobID: msg.JobID, Result: result})
+	}
+}
+
+// modelCache holds the most recently used model so consecutive jobs skip
+// reloading it from disk
+type modelCache struct {
+	path  string
+	model whisper.Model
+}
+
+// get returns the model at path, loading it if a different one is cached
+func (c *modelCache) get(path string) (whisper.Model, error) {
+	if c.model != nil && c.path == path {
+		return c.model, nil
+	}
+	c.close()
+
+	model, err := whisper.New(path)
+	if err != nil {
+		return nil, err
 	}
+	c.path = path
+	c.model = model
+	return model, nil
+}
 
-	log.Printf("[Worker %s] Starting transcription for %s", req.JobID, req.AudioPath)
+func (c *modelCache) close() {
+	if c.model != nil {
+		c.model.Close()
+		c.model = nil
+		c.path = ""
+	}
+}
+
+func transcribe(enc *protocol.Encoder, cache *modelCache, jobID string, req protocol.Request) (*protocol.Result, error) {
+	log.Printf("[Worker %s] Starting transcription for %s", jobID, req.AudioPath)
 	startTime := time.Now()
 
-	// Load model
-	model, err := whisper.New(req.ModelPath)
+	sendProgress := func(stage string, percent float64) {
+		enc.Send(protocol.Message{Type: protocol.TypeProgress, JobID: jobID, Progress: &protocol.Progress{Stage: stage, Percent: percent}})
+	}
+
+	// Load model, unless this process already has it
+	sendProgress(protocol.StageLoadingModel, 0)
+	model, err := cache.get(req.ModelPath)
 	if err != nil {
-		sendError(fmt.Sprintf("Failed to load model: %v", err))
-		return
+		return nil, fmt.Errorf("failed to load model: %w", err)
 	}
-	defer model.Close()
 
 	// Load audio
-	audioData, err := loadAudioData(req.AudioPath)
+	sendProgress(protocol.StageDecodingAudio, 0)
+	audioData, err := loadAudioData(req.AudioPath, req.Channel)
 	if err != nil {
-		sendError(fmt.Sprintf("Failed to load audio: %v", err))
-		return
+		return nil, fmt.Errorf("failed to load audio: %w", err)
 	}
 
 	// Create context
 	context, err := model.NewContext()
 	if err != nil {
-		sendError(fmt.Sprintf("Failed to create context: %v", err))
-		return
+		return nil, fmt.Errorf("failed to create context: %w", err)
+	}
+
+	if req.Threads > 0 {
+		context.SetThreads(uint(req.Threads))
 	}
 
 	// Set language if specified
@@ -78,16 +155,32 @@ func main() {
 		context.SetLanguage(req.Language)
 	}
 
+	context.SetTranslate(req.Translate)
+	if req.InitialPrompt != "" {
+		context.SetInitialPrompt(req.InitialPrompt)
+	}
+	if req.Temperature > 0 {
+		context.SetTemperature(req.Temperature)
+	}
+	context.SetTokenTimestamps(req.WordTimestamps)
+
 	// Process audio
-	log.Printf("[Worker %s] Processing audio...", req.JobID)
-	if err := context.Process(audioData, nil, nil, nil); err != nil {
-		sendError(fmt.Sprintf("Failed to process audio: %v", err))
-		return
+	log.Printf("[Worker %s] Processing audio...", jobID)
+	sendProgress(protocol.StageTranscribing, 0)
+	progressCallback := func(progress int) {
+		sendProgress(protocol.StageTranscribing, float64(progress))
+	}
+	segmentCallback := func(segment whisper.Segment) {
+		seg := toSegment(context, segment, req.WordTimestamps)
+		enc.Send(protocol.Message{Type: protocol.TypeSegment, JobID: jobID, Segment: &seg})
+	}
+	if err := context.Process(audioData, nil, segmentCallback, progressCallback); err != nil {
+		return nil, fmt.Errorf("failed to process audio: %w", err)
 	}
 
 	// Extract transcription
 	var fullText string
-	var segments []TranscriptionSegment
+	var segments []protocol.Segment
 
 	for {
 		segment, err := context.NextSegment()
@@ -95,54 +188,95 @@ func main() {
 			break
 		}
 
-		text := segment.Text
-		fullText += text + " "
-
-		segments = append(segments, TranscriptionSegment{
-			Start: float64(segment.Start.Milliseconds()) / 1000.0,
-			End:   float64(segment.End.Milliseconds()) / 1000.0,
-			Text:  text,
-		})
+		fullText += segment.Text + " "
+		segments = append(segments, toSegment(context, segment, req.WordTimestamps))
 	}
 
 	duration := time.Since(startTime).Seconds()
-	log.Printf("[Worker %s] Transcription complete in %.2fs", req.JobID, duration)
+	log.Printf("[Worker %s] Transcription complete in %.2fs", jobID, duration)
 
-	// Send success response
-	resp := WorkerResponse{
-		Success:  true,
+	return &protocol.Result{
 		Text:     fullText,
 		Segments: segments,
+		Language: context.DetectedLanguage(),
 		Duration: duration,
-	}
+		MaxRSS:   maxRSS(),
+	}, nil
+}
 
-	data, _ := json.Marshal(resp)
-	fmt.Println(string(data))
+// toSegment converts a whisper segment to the protocol format, with its
+// words if requested
+func toSegment(context whisper.Context, segment whisper.Segment, words bool) protocol.Segment {
+	seg := protocol.Segment{
+		Start: seconds(segment.Start),
+		End:   seconds(segment.End),
+		Text:  segment.Text,
+	}
+	if words {
+		seg.Words = toWords(context, segment.Tokens)
+	}
+	return seg
 }
 
-func sendError(errMsg string) {
-	log.Printf("[Worker] Error: %s", errMsg)
-	resp := WorkerResponse{
-		Success: false,
-		Error:   errMsg,
+// toWords joins a segment's text tokens into words. Whisper's tokens are
+// pieces of words; a token that starts with a space begins a new word.
+func toWords(context whisper.Context, tokens []whisper.Token) []protocol.Word {
+	var words []protocol.Word
+	var probSum float64
+	var probCount int
+
+	finish := func() {
+		if n := len(words); n > 0 && probCount > 0 {
+			words[n-1].Text = strings.TrimSpace(words[n-1].Text)
+			words[n-1].Probability = probSum / float64(probCount)
+		}
+		probSum, probCount = 0, 0
 	}
-	data, _ := json.Marshal(resp)
-	fmt.Println(string(data))
-	os.Exit(1)
+
+	for _, token := range tokens {
+		// Skip timestamp and control tokens, including the [_TT_..] markers
+		if !context.IsText(token) || strings.HasPrefix(token.Text, "[_") {
+			continue
+		}
+		if len(words) == 0 || strings.HasPrefix(token.Text, " ") {
+			finish()
+			words = append(words, protocol.Word{Start: seconds(token.Start)})
+		}
+		word := &words[len(words)-1]
+		word.Text += token.Text
+		word.End = seconds(token.End)
+		probSum += float64(token.P)
+		probCount++
+	}
+	finish()
+
+	// Drop words that were only whitespace
+	kept := words[:0]
+	for _, word := range words {
+		if word.Text != "" {
+			kept = append(kept, word)
+		}
+	}
+	return kept
 }
 
-func loadAudioData(audioPath string) ([]float32, error) {
+// seconds converts a whisper timestamp to seconds at millisecond precision
+func seconds(d time.Duration) float64 {
+	return float64(d.Milliseconds()) / 1000.0
+}
+
+// loadAudioData decodes the audio to 16 kHz mono samples. A channel above 0
+// takes only that channel instead of mixing them all.
+func loadAudioData(audioPath string, channel int) ([]float32, error) {
 	wavPath := audioPath + ".wav"
 	defer os.Remove(wavPath)
 
-	cmd := exec.Command("ffmpeg",
-		"-i", audioPath,
-		"-ar", "16000",
-		"-ac", "1",
-		"-c:a", "pcm_s16le",
-		"-f", "wav",
-		"-y",
-		wavPath)
+	args := []string{"-i", audioPath, "-ar", "16000", "-ac", "1"}
+	if channel > 0 {
+		args = append(args, "-af", fmt.Sprintf("pan=mono|c0=c%d", channel-1))
+	}
+	args = append(args, "-c:a", "pcm_s16le", "-f", "wav", "-y", wavPath)
+	cmd := exec.Command("ffmpeg", args...)
 
 	if err := cmd.Run(); err != nil {
 		return nil, fmt.Errorf("ffmpeg conversion failed: %w", err)
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// maxRSS returns the peak resident set size of the worker in bytes
+func maxRSS() int64 {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	// ru_maxrss is in bytes on macOS and kilobytes elsewhere
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss)
+	}
+	return int64(usage.Maxrss) * 1024
+}
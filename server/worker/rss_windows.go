@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+// maxRSS is not reported on Windows, so memory-based recycling is disabled
+func maxRSS() int64 {
+	return 0
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hnrqer/transcriber-pro/protocol"
+)
+
+// maxKillAuditEntries bounds the in-memory kill audit; the on-disk log keeps
+// everything.
+const maxKillAuditEntries = 200
+
+var (
+	ErrJobNotFound   = errors.New("job not found")
+	ErrJobNotRunning = errors.New("job is not running")
+)
+
+// workerProcess is a running transcriber-worker bound to a single job
+type workerProcess struct {
+	JobID     string
+	PID       int
+	StartedAt time.Time
+	cmd       *exec.Cmd
+}
+
+// KillRecord is an audit entry for a worker process terminated on behalf of
+// a job
+type KillRecord struct {
+	Time   time.Time `json:"time"`
+	JobID  string    `json:"job_id"`
+	PID    int       `json:"pid"`
+	Reason string    `json:"reason"` // "kill" or "cancel"
+	Error  string    `json:"error,omitempty"`
+}
+
+// workerRegistry maps job IDs to the worker processes running them, so a
+// kill can only ever reach the process that belongs to the job it names.
+type workerRegistry struct {
+	mu        sync.Mutex
+	procs     map[string]*workerProcess
+	audit     []KillRecord
+	auditPath string // Append-only audit log, empty to keep it in memory only
+}
+
+func newWorkerRegistry(auditPath string) *workerRegistry {
+	return &workerRegistry{
+		procs:     make(map[string]*workerProcess),
+		auditPath: auditPath,
+	}
+}
+
+// register records a started worker process for jobID
+func (r *workerRegistry) register(jobID string, cmd *exec.Cmd) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.procs[jobID] = &workerProcess{
+		JobID:     jobID,
+		PID:       cmd.Process.Pid,
+		StartedAt: time.Now(),
+		cmd:       cmd,
+	}
+}
+
+// unregister forgets the worker process for jobID once it has exited. It only
+// removes the entry if it still refers to cmd.
+func (r *workerRegistry) unregister(jobID string, cmd *exec.Cmd) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if proc, ok := r.procs[jobID]; ok && proc.cmd == cmd {
+		delete(r.procs, jobID)
+	}
+}
+
+// kill terminates the worker process registered for jobID and records it in
+// the audit log. It returns nil and no error if no process is registered.
+func (r *workerRegistry) kill(jobID, reason string) (*KillRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	proc, ok := r.procs[jobID]
+	if !ok {
+		return nil, nil
+	}
+
+	record := KillRecord{
+		Time:   time.Now(),
+		JobID:  jobID,
+		PID:    proc.PID,
+		Reason: reason,
+	}
+
+	log.Printf("[Job %s] Killing worker process (PID: %d)", jobID, proc.PID)
+	err := proc.cmd.Process.Kill()
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		record.Error = err.Error()
+		err = fmt.Errorf("failed to kill worker process %d: %w", proc.PID, err)
+	} else {
+		err = nil
+		log.Printf("[Audit] Terminated worker PID %d for job %s (%s)", proc.PID, jobID, reason)
+	}
+
+	r.recordLocked(record)
+	return &record, err
+}
+
+func (r *workerRegistry) recordLocked(record KillRecord) {
+	r.audit = append(r.audit, record)
+	if len(r.audit) > maxKillAuditEntries {
+		r.audit = r.audit[len(r.audit)-maxKillAuditEntries:]
+	}
+
+	if r.auditPath == "" {
+		return
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	file, err := os.OpenFile(r.auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Printf("[Audit] Failed to open kill audit log: %v", err)
+		return
+	}
+	defer file.Close()
+	file.Write(append(data, '\n'))
+}
+
+// Audit returns the most recent kill records, oldest first
+func (r *workerRegistry) Audit() []KillRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := make([]KillRecord, len(r.audit))
+	copy(records, r.audit)
+	return records
+}
+
+// warmWorker is a long-lived transcriber-worker process owned by a worker
+// slot. It keeps its model loaded between jobs and serves them one at a time.
+type warmWorker struct {
+	slot   int
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	enc    *protocol.Encoder
+	dec    *protocol.Decoder
+	jobs   int // Jobs served by this process
+}
+
+// requestError is a failure the worker reported for a single request. The
+// worker process is still healthy afterwards and can serve the next job.
+type requestError struct {
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// startWarmWorker spawns a worker process and performs the protocol handshake
+func startWarmWorker(slot int, workerPath string) (*warmWorker, error) {
+	cmd := exec.Command(workerPath)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start worker: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start worker: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start worker: %w", err)
+	}
+
+	w := &warmWorker{
+		slot:   slot,
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: stdout,
+		enc:    protocol.NewEncoder(stdin),
+		dec:    protocol.NewDecoder(stdout),
+	}
+
+	if err := w.handshake(); err != nil {
+		w.stop()
+		return nil, fmt.Errorf("worker handshake failed: %w", err)
+	}
+
+	log.Printf("[Slot %d] Worker started (PID: %d)", slot, cmd.Process.Pid)
+	return w, nil
+}
+
+func (w *warmWorker) handshake() error {
+	if err := w.enc.Send(protocol.NewHello(Version)); err != nil {
+		return err
+	}
+
+	hello, err := w.next()
+	if err != nil {
+		return err
+	}
+	if err := protocol.CheckHello(hello); err != nil {
+		return err
+	}
+	if hello.Hello.Version != Version {
+		log.Printf("[Slot %d] Worker build %s differs from server build %s", w.slot, hello.Hello.Version, Version)
+	}
+	return nil
+}
+
+// next reads the next frame, skipping stray non-protocol output
+func (w *warmWorker) next() (*protocol.Message, error) {
+	for {
+		msg, err := w.dec.Next()
+		if errors.Is(err, protocol.ErrInvalidFrame) {
+			log.Printf("[Slot %d] Ignoring non-protocol worker output: %v", w.slot, err)
+			continue
+		}
+		return msg, err
+	}
+}
+
+// stop asks the worker to exit by closing its stdin and reaps it, killing it
+// if it does not exit in time
+func (w *warmWorker) stop() {
+	w.stdin.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, w.stdout)
+		w.cmd.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		log.Printf("[Slot %d] Worker did not exit, killing it (PID: %d)", w.slot, w.cmd.Process.Pid)
+		w.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// workerLimits decide when a warm worker is recycled
+type workerLimits struct {
+	maxJobs int   // Restart after this many jobs (0 = never)
+	maxRSS  int64 // Restart once peak RSS exceeds this many bytes (0 = never)
+}
+
+// newWorkerLimits returns the recycling limits from the configuration
+func newWorkerLimits(cfg *Config) workerLimits {
+	return workerLimits{
+		maxJobs: cfg.WorkerMaxJobs,
+		maxRSS:  cfg.WorkerMaxRSSMB * mb,
+	}
+}
+
+// slotWorker returns the warm worker for slot, spawning one if the slot has
+// none or its previous process was killed or recycled
+func (e *TranscriptionEngine) slotWorker(slot int) (*warmWorker, error) {
+	e.warmMutex.Lock()
+	defer e.warmMutex.Unlock()
+
+	if w := e.warmWorkers[slot]; w != nil {
+		return w, nil
+	}
+
+	// Get the worker binary path - use absolute path of current executable
+	exePath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get executable path: %w", err)
+	}
+	workerPath := filepath.Join(filepath.Dir(exePath), "transcriber-worker")
+
+	w, err := startWarmWorker(slot, workerPath)
+	if err != nil {
+		return nil, err
+	}
+	e.warmWorkers[slot] = w
+	return w, nil
+}
+
+// retireWorker stops the slot's worker; the next job spawns a fresh one
+func (e *TranscriptionEngine) retireWorker(slot int, w *warmWorker) {
+	e.warmMutex.Lock()
+	if e.warmWorkers[slot] == w {
+		delete(e.warmWorkers, slot)
+	}
+	e.warmMutex.Unlock()
+
+	w.stop()
+}
+
+// stopWorkers shuts down all idle and running worker processes
+func (e *TranscriptionEngine) stopWorkers() {
+	e.warmMutex.Lock()
+	workers := e.warmWorkers
+	e.warmWorkers = make(map[int]*warmWorker)
+	e.warmMutex.Unlock()
+
+	for _, w := range workers {
+		w.stop()
+	}
+}
+
+// workerPass places one worker request within a job that takes several, such
+// as one per audio channel. The zero value is a job's only pass.
+type workerPass struct {
+	Index   int    // Counting from 0
+	Count   int    // Passes in the job, 0 for a single pass
+	Label   string // Shown in progress messages, e.g. "channel 2"
+	Speaker string // Set on every segment the pass produces
+}
+
+// runWorker sends req to the slot's warm worker and relays the worker's
+// events to the job until it returns a result or an error. A worker whose
+// session breaks (killed, crashed, protocol error) is discarded, and one that
+// reaches its recycling limits is restarted.
+func (e *TranscriptionEngine) runWorker(slot int, jobID string, req protocol.Request, pass workerPass) (*protocol.Result, error) {
+	w, err := e.slotWorker(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	// Register the process under this job so kills can only reach it
+	e.workers.register(jobID, w.cmd)
+	log.Printf("[Job %s] Running on slot %d worker (PID: %d, job #%d)", jobID, slot, w.cmd.Process.Pid, w.jobs+1)
+
+	// A kill that arrived before the process was registered found nothing to stop
+	if e.IsCancelled(jobID) {
+		e.workers.kill(jobID, "cancel")
+	}
+
+	result, err := e.converse(w, jobID, req, pass)
+	e.workers.unregister(jobID, w.cmd)
+
+	var reqErr *requestError
+	if err != nil && !errors.As(err, &reqErr) {
+		log.Printf("[Slot %d] Worker session ended: %v", slot, err)
+		e.retireWorker(slot, w)
+		return nil, err
+	}
+
+	w.jobs++
+	if e.limits.maxJobs > 0 && w.jobs >= e.limits.maxJobs {
+		log.Printf("[Slot %d] Recycling worker after %d jobs", slot, w.jobs)
+		e.retireWorker(slot, w)
+	} else if result != nil && e.limits.maxRSS > 0 && result.MaxRSS > e.limits.maxRSS {
+		log.Printf("[Slot %d] Recycling worker at %d MB peak RSS", slot, result.MaxRSS/1024/1024)
+		e.retireWorker(slot, w)
+	}
+
+	return result, err
+}
+
+// converse runs a single request on a warm worker
+func (e *TranscriptionEngine) converse(w *warmWorker, jobID string, req protocol.Request, pass workerPass) (*protocol.Result, error) {
+	if err := w.enc.Send(protocol.Message{Type: protocol.TypeRequest, JobID: jobID, Request: &req}); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	tracker := &progressTracker{}
+	for {
+		msg, err := w.next()
+		if err != nil {
+			return nil, fmt.Errorf("worker exited without a result: %w", err)
+		}
+
+		switch msg.Type {
+		case protocol.TypeLog:
+			if msg.Log != nil {
+				log.Print(msg.Log.Message)
+			}
+		case protocol.TypeProgress:
+			if msg.Progress != nil {
+				e.handleProgress(jobID, tracker, *msg.Progress, pass)
+			}
+		case protocol.TypeSegment:
+			if msg.Segment != nil && !e.IsCancelled(jobID) {
+				segment := *msg.Segment
+				if pass.Speaker != "" {
+					segment.Speaker = pass.Speaker
+				}
+				e.appendPartialSegment(jobID, segment)
+			}
+		case protocol.TypeResult:
+			if msg.Result == nil {
+				return nil, &requestError{"worker sent an empty result"}
+			}
+			return msg.Result, nil
+		case protocol.TypeError:
+			if msg.Error == nil {
+				return nil, &requestError{"worker reported an unknown error"}
+			}
+			return nil, &requestError{msg.Error.Message}
+		default:
+			log.Printf("[Job %s] Ignoring unexpected %q frame from worker", jobID, msg.Type)
+		}
+	}
+}
+
+// handleProgress updates the job from a worker progress frame. The progress
+// of a pass is scaled to its share of the job.
+func (e *TranscriptionEngine) handleProgress(jobID string, tracker *progressTracker, progress protocol.Progress, pass workerPass) {
+	// Never overwrite the state of a cancelled or killed job
+	if e.IsCancelled(jobID) {
+		return
+	}
+
+	count := max(pass.Count, 1)
+	base := float64(pass.Index) * 100 / float64(count)
+	suffix := ""
+	if pass.Label != "" {
+		suffix = fmt.Sprintf(" (%s of %d)", pass.Label, count)
+	}
+
+	// Later passes load the model and decode audio again, but the job stays
+	// transcribing so it isn't reported as started once per pass
+	preparing := StatusProcessing
+	if pass.Index > 0 {
+		preparing = StatusTranscribing
+	}
+
+	switch progress.Stage {
+	case protocol.StageLoadingModel:
+		e.updateJob(jobID, preparing, base, "Loading model..."+suffix, "", nil, "")
+	case protocol.StageDecodingAudio:
+		e.updateJob(jobID, preparing, base, "Decoding audio..."+suffix, "", nil, "")
+	case protocol.StageTranscribing:
+		// 100% is reported once the result has been received
+		percent := min(base+progress.Percent/float64(count), 99)
+		eta := tracker.update(percent)
+		e.updateJob(jobID, StatusTranscribing, percent, fmt.Sprintf("Transcribing... %.0f%%%s", percent, suffix), eta, nil, "")
+	}
+}
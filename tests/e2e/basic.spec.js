@@ -84,6 +84,19 @@ test.describe('Transcriber Pro - Basic Functionality', () => {
     expect(Array.isArray(data.queue)).toBeTruthy();
     expect(Array.isArray(data.completed)).toBeTruthy();
   });
+
+  test('models endpoint lists catalog and default', async ({ request }) => {
+    const response = await request.get('/models');
+    expect(response.ok()).toBeTruthy();
+
+    const data = await response.json();
+    expect(data.default).toBeTruthy();
+    expect(Array.isArray(data.models)).toBeTruthy();
+
+    const defaultModel = data.models.find(model => model.name === data.default);
+    expect(defaultModel).toBeTruthy();
+    expect(defaultModel.installed).toBeTruthy();
+  });
 });
 
 test.describe('Transcriber Pro - UI Interactions', () => {